@@ -0,0 +1,175 @@
+// ca-test runs the mock servers of this module as a standalone process, e.g. as a sidecar in docker-compose or in local end to end runs.
+//
+// Usage:
+//
+//	ca-test [-config config.yaml] [-host 0.0.0.0] [-port 8080] [-tls] [-record ./recordings] [-stubs ./stubs] [-elastic] [-admin /__admin]
+//
+// Flags override the values of the config file. The server shuts down gracefully on SIGTERM or SIGINT.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/armosec/ca-test/elastic"
+	"github.com/armosec/ca-test/server"
+	"gopkg.in/yaml.v3"
+)
+
+// config is the content of the config file
+type config struct {
+	Port                int               `yaml:"port"`
+	Host                string            `yaml:"host"`
+	TLS                 bool              `yaml:"tls"`
+	HTTP2               bool              `yaml:"http2"`
+	H2C                 bool              `yaml:"h2c"`
+	RecordFolder        string            `yaml:"recordFolder"`
+	RecordOnlyUnhandled bool              `yaml:"recordOnlyUnhandled"`
+	StubsDir            string            `yaml:"stubsDir"`
+	Headers             map[string]string `yaml:"headers"`
+	UnmatchedStatus     int               `yaml:"unmatchedStatus"`
+	AdminPrefix         string            `yaml:"adminPrefix"`
+	AdminPort           *int              `yaml:"adminPort"`
+	Proxy               *proxyConfig      `yaml:"proxy"`
+	Elastic             *elasticConfig    `yaml:"elastic"`
+}
+
+type proxyConfig struct {
+	Upstream string `yaml:"upstream"`
+	All      bool   `yaml:"all"`
+}
+
+type elasticConfig struct {
+	//IndicesMappingFile is a JSON file of index prefix to mapping, the built in mapping is used if empty
+	IndicesMappingFile string `yaml:"indicesMappingFile"`
+}
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run() error {
+	configFile := flag.String("config", "", "path of a YAML config file")
+	port := flag.Int("port", 0, "server port, 0 picks the next available port")
+	host := flag.String("host", "", "address to listen on, e.g. 0.0.0.0 when running in a container (default 127.0.0.1)")
+	tls := flag.Bool("tls", false, "serve over TLS")
+	recordFolder := flag.String("record", "", "record the requests to this folder")
+	stubsDir := flag.String("stubs", "", "load the stub files of this directory")
+	withElastic := flag.Bool("elastic", false, "start the elastic mock server")
+	adminPrefix := flag.String("admin", "", "serve the admin API under this path prefix, e.g. /__admin")
+	flag.Parse()
+
+	cfg := config{}
+	if *configFile != "" {
+		data, err := os.ReadFile(*configFile)
+		if err != nil {
+			return err
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&cfg); err != nil {
+			return fmt.Errorf("invalid config file %s: %v", *configFile, err)
+		}
+	}
+	//flags override the config file
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			cfg.Port = *port
+		case "host":
+			cfg.Host = *host
+		case "tls":
+			cfg.TLS = *tls
+		case "record":
+			cfg.RecordFolder = *recordFolder
+		case "stubs":
+			cfg.StubsDir = *stubsDir
+		case "elastic":
+			if !*withElastic {
+				cfg.Elastic = nil
+			} else if cfg.Elastic == nil {
+				cfg.Elastic = &elasticConfig{}
+			}
+		case "admin":
+			cfg.AdminPrefix = *adminPrefix
+		}
+	})
+
+	ts, err := startServer(cfg)
+	if err != nil {
+		return err
+	}
+	log.Printf("mock server listening on %s", ts.GetURL())
+	if adminURL := ts.GetAdminURL(); adminURL != "" {
+		log.Printf("admin API listening on %s", adminURL)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	<-ctx.Done()
+	log.Printf("shutting down")
+	ts.Close()
+	return nil
+}
+
+func startServer(cfg config) (server.TestServer, error) {
+	opts := []server.ServerOption{server.WithPort(cfg.Port)}
+	if cfg.Host != "" {
+		opts = append(opts, server.WithHost(cfg.Host))
+	}
+	if cfg.TLS {
+		opts = append(opts, server.WithTLS())
+	}
+	if cfg.HTTP2 {
+		opts = append(opts, server.WithHTTP2())
+	}
+	if cfg.H2C {
+		opts = append(opts, server.WithH2C())
+	}
+	if cfg.RecordFolder != "" {
+		opts = append(opts, server.WithRequestsRecorder(true, cfg.RecordFolder, 0, cfg.RecordOnlyUnhandled))
+	}
+	if cfg.StubsDir != "" {
+		opts = append(opts, server.WithStubsDir(cfg.StubsDir))
+	}
+	if len(cfg.Headers) != 0 {
+		opts = append(opts, server.WithHeaders(cfg.Headers))
+	}
+	if cfg.UnmatchedStatus != 0 {
+		opts = append(opts, server.WithUnmatchedResponse(cfg.UnmatchedStatus))
+	}
+	if cfg.AdminPrefix != "" {
+		opts = append(opts, server.WithAdminAPI(cfg.AdminPrefix))
+	}
+	if cfg.AdminPort != nil {
+		opts = append(opts, server.WithAdminPort(*cfg.AdminPort))
+	}
+	if cfg.Proxy != nil {
+		opts = append(opts, server.WithProxy(cfg.Proxy.Upstream, cfg.Proxy.All))
+	}
+	if cfg.Elastic == nil {
+		return server.NewTestServer(opts...)
+	}
+	esOpts := []elastic.ElasticServerOption{}
+	if cfg.Elastic.IndicesMappingFile != "" {
+		data, err := os.ReadFile(cfg.Elastic.IndicesMappingFile)
+		if err != nil {
+			return nil, err
+		}
+		indicesMapping := map[string]string{}
+		if err := json.Unmarshal(data, &indicesMapping); err != nil {
+			return nil, fmt.Errorf("invalid indices mapping file %s: %v", cfg.Elastic.IndicesMappingFile, err)
+		}
+		esOpts = append(esOpts, elastic.WithIndicesMapping(indicesMapping))
+	}
+	return elastic.NewElasticServer(esOpts, opts...)
+}
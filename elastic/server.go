@@ -20,18 +20,30 @@ type elasticServer struct {
 }
 
 func NewElastic(t *testing.T, esOpts []ElasticServerOption, opts ...server.ServerOption) ElasticServer {
-	esOptions, err := makeOptions(esOpts...)
+	es, err := NewElasticServer(esOpts, opts...)
 	if err != nil {
 		t.Fatal(err)
 	}
+	return es
+}
 
+// NewElasticServer creates an elastic mock server without a test, e.g. for running it as a standalone process
+func NewElasticServer(esOpts []ElasticServerOption, opts ...server.ServerOption) (ElasticServer, error) {
+	esOptions, err := makeOptions(esOpts...)
+	if err != nil {
+		return nil, err
+	}
+	testServer, err := createServer(*esOptions, opts...)
+	if err != nil {
+		return nil, err
+	}
 	return &elasticServer{
-		TestServer: createServer(t, *esOptions, opts...),
+		TestServer: testServer,
 		options:    *esOptions,
-	}
+	}, nil
 }
 
-func createServer(t *testing.T, esOpts options, opts ...server.ServerOption) server.TestServer {
+func createServer(esOpts options, opts ...server.ServerOption) (server.TestServer, error) {
 
 	commonOptions := []server.ServerOption{
 		server.WithHeaders(map[string]string{
@@ -49,7 +61,7 @@ func createServer(t *testing.T, esOpts options, opts ...server.ServerOption) ser
 		),
 		server.WithBuiltInHandler(
 			server.WithMethod(http.MethodPut),
-			server.WithPath("/*/_settings"),
+			server.WithPathGlob("/*/_settings"),
 			server.WithResponse(ackResponse),
 		),
 	}
@@ -59,29 +71,27 @@ func createServer(t *testing.T, esOpts options, opts ...server.ServerOption) ser
 		commonOptions = append(commonOptions, opts...)
 	}
 
-	IndicesOptions := addIndicesHandlers(t, esOpts)
-
-	//create and start the mock server
-	elasticMock, err := server.NewTestServer(append(commonOptions, IndicesOptions...)...)
+	IndicesOptions, err := addIndicesHandlers(esOpts)
 	if err != nil {
-		t.Fatal(err)
+		return nil, err
 	}
-	return elasticMock
 
+	//create and start the mock server
+	return server.NewTestServer(append(commonOptions, IndicesOptions...)...)
 }
 
 func (es *elasticServer) GetIndexSuffix() string {
 	return es.options.indexSuffix
 }
 
-func addIndicesHandlers(t *testing.T, esOpts options) []server.ServerOption {
+func addIndicesHandlers(esOpts options) ([]server.ServerOption, error) {
 	var indicesMapping map[string]string
 	if len(esOpts.indexPrefix2Mapping) > 0 {
 		indicesMapping = esOpts.indexPrefix2Mapping
 	} else {
 		indicesMapping = make(map[string]string)
 		if err := json.Unmarshal(indicesMappingsBytes, &indicesMapping); err != nil {
-			t.Fatal(err)
+			return nil, err
 		}
 	}
 
@@ -125,5 +135,5 @@ func addIndicesHandlers(t *testing.T, esOpts options) []server.ServerOption {
 				server.WithResponse(indexMappingResponse(indexName+esOpts.indexSuffix, mapping)),
 			))
 	}
-	return options
+	return options, nil
 }
@@ -0,0 +1,66 @@
+package grpcserver
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// rawFrame is the encoded protobuf message of a gRPC frame, the server does not need the generated types of the services it mocks
+type rawFrame struct {
+	data []byte
+}
+
+// rawCodec passes the encoded messages through, messages are decoded with the descriptors of the methods when they are known
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	frame, ok := v.(*rawFrame)
+	if !ok {
+		return nil, fmt.Errorf("unexpected message type %T", v)
+	}
+	return frame.data, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	frame, ok := v.(*rawFrame)
+	if !ok {
+		return fmt.Errorf("unexpected message type %T", v)
+	}
+	frame.data = append([]byte{}, data...)
+	return nil
+}
+
+// Name is the name of the proto codec so the content type of the messages is application/grpc+proto
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+// lookupMethod returns the descriptor of a method registered by the generated code linked into the test binary
+func lookupMethod(fullMethod string) (protoreflect.MethodDescriptor, bool) {
+	service, method, err := splitMethod(fullMethod)
+	if err != nil {
+		return nil, false
+	}
+	descriptor, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, false
+	}
+	serviceDescriptor, ok := descriptor.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, false
+	}
+	methodDescriptor := serviceDescriptor.Methods().ByName(protoreflect.Name(method))
+	return methodDescriptor, methodDescriptor != nil
+}
+
+// newMessage returns an empty message of the descriptor, of the generated type if it is registered
+func newMessage(descriptor protoreflect.MessageDescriptor) proto.Message {
+	if messageType, err := protoregistry.GlobalTypes.FindMessageByName(descriptor.FullName()); err == nil {
+		return messageType.New().Interface()
+	}
+	return dynamicpb.NewMessage(descriptor)
+}
@@ -0,0 +1,34 @@
+package grpcserver
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// RecordedCall is a call captured by the server journal
+type RecordedCall struct {
+	//Method is the full method name, e.g. /grpc.health.v1.Health/Check
+	Method   string              `json:"method"`
+	Metadata map[string][]string `json:"metadata,omitempty"`
+	//Request is the decoded request message, nil if the request type of the method is unknown (see WithRequestType)
+	Request proto.Message `json:"-"`
+	//RequestJSON is the JSON encoding of the request message, empty if the request type of the method is unknown
+	RequestJSON string `json:"request,omitempty"`
+	//RawRequest is the encoded request message
+	RawRequest []byte `json:"raw_request,omitempty"`
+	CallNumber int    `json:"call_num"`
+	//Stub is the name of the stub that handled the call, empty if no stub matched
+	Stub string `json:"stub,omitempty"`
+	//Code is the status code the call ended with
+	Code      string    `json:"code"`
+	Timestamp time.Time `json:"timestamp"`
+	//Duration is the time it took the server to respond
+	Duration time.Duration `json:"duration"`
+}
+
+func copyRecordedCalls(calls []RecordedCall) []RecordedCall {
+	result := make([]RecordedCall, len(calls))
+	copy(result, calls)
+	return result
+}
@@ -0,0 +1,251 @@
+package grpcserver
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const localHost = "127.0.0.1"
+
+// TestServer is a gRPC mock server, the gRPC counterpart of server.TestServer.
+// Calls of any method are answered by the stubs, unary and server streaming methods are supported
+type TestServer interface {
+	//get the server address to dial, host:port
+	GetURL() string
+	//get server port
+	GetPort() int
+	//get server port as string
+	GetPortAsString() string
+	//get the current number of calls received by the server
+	GetCallCount() int
+	//get all the calls received by the server in the order they were received
+	GetCalls() []RecordedCall
+	//get the received calls of a method, e.g. /grpc.health.v1.Health/Check
+	FindCalls(fullMethod string) []RecordedCall
+	//SetOption sets a new option to the server, error is return if the option cannot be modified
+	SetOption(opt ServerOption) error
+	//Adds a stub for a method, stubs added later take precedence over stubs added before them and over the built-in stubs
+	AddStub(opts ...StubOption) error
+	//Resets all stubs, the built-in stubs are not effected
+	ResetStubs()
+	//Closes the server
+	Close()
+}
+
+func NewTestServer(opts ...ServerOption) (TestServer, error) {
+	options, err := makeServerOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	ts := &mockGRPCServer{
+		options: *options,
+		mux:     &sync.Mutex{},
+		stubs:   []*stub{},
+		closed:  make(chan struct{}),
+	}
+	if err := ts.startServer(); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// NewTestServerWithCleanup creates a test server that is closed automatically when the test and all its subtests complete
+func NewTestServerWithCleanup(t *testing.T, opts ...ServerOption) TestServer {
+	ts, err := NewTestServer(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+type mockGRPCServer struct {
+	server    *grpc.Server
+	mux       *sync.Mutex
+	options   serverOptions
+	callCount int
+	stubs     []*stub
+	journal   []RecordedCall
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (ts *mockGRPCServer) GetURL() string {
+	return fmt.Sprintf("%s:%d", localHost, ts.options.port)
+}
+
+func (ts *mockGRPCServer) GetPort() int {
+	return ts.options.port
+}
+
+func (ts *mockGRPCServer) GetPortAsString() string {
+	return fmt.Sprintf("%d", ts.options.port)
+}
+
+func (ts *mockGRPCServer) SetOption(opt ServerOption) error {
+	ts.mux.Lock()
+	defer ts.mux.Unlock()
+	_, err := applyOptions(&ts.options, true, opt)
+	return err
+}
+
+func (ts *mockGRPCServer) GetCallCount() int {
+	ts.mux.Lock()
+	defer ts.mux.Unlock()
+	return ts.callCount
+}
+
+func (ts *mockGRPCServer) GetCalls() []RecordedCall {
+	ts.mux.Lock()
+	defer ts.mux.Unlock()
+	return copyRecordedCalls(ts.journal)
+}
+
+func (ts *mockGRPCServer) FindCalls(fullMethod string) []RecordedCall {
+	ts.mux.Lock()
+	defer ts.mux.Unlock()
+	calls := []RecordedCall{}
+	for _, call := range ts.journal {
+		if call.Method == fullMethod {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func (ts *mockGRPCServer) AddStub(opts ...StubOption) error {
+	stub, err := newStub(opts...)
+	if err != nil {
+		return err
+	}
+	ts.mux.Lock()
+	defer ts.mux.Unlock()
+	ts.stubs = append(ts.stubs, stub)
+	return nil
+}
+
+func (ts *mockGRPCServer) ResetStubs() {
+	ts.mux.Lock()
+	defer ts.mux.Unlock()
+	ts.stubs = []*stub{}
+}
+
+func (ts *mockGRPCServer) Close() {
+	ts.closeOnce.Do(func() {
+		//release delayed calls so the server can shut down
+		close(ts.closed)
+		ts.server.Stop()
+	})
+}
+
+func (ts *mockGRPCServer) startServer() error {
+	l, err := net.Listen("tcp", fmt.Sprintf("%s:%d", ts.options.host, ts.options.port))
+	if err != nil {
+		return err
+	}
+	//update port in options in case a new port was allocated
+	ts.options.port = l.Addr().(*net.TCPAddr).Port
+	opts := append([]grpc.ServerOption{
+		grpc.ForceServerCodec(rawCodec{}),
+		grpc.UnknownServiceHandler(ts.handleCall),
+	}, ts.options.grpcOptions...)
+	ts.server = grpc.NewServer(opts...)
+	go ts.server.Serve(l)
+	return nil
+}
+
+// handleCall receives the request message of any call and serves it with the matching stub
+func (ts *mockGRPCServer) handleCall(srv interface{}, stream grpc.ServerStream) error {
+	receivedAt := time.Now()
+	method, _ := grpc.MethodFromServerStream(stream)
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	frame := &rawFrame{}
+	if err := stream.RecvMsg(frame); err != nil && err != io.EOF {
+		return err
+	}
+	call := &incomingCall{method: method, metadata: md, raw: frame.data}
+
+	ts.mux.Lock()
+	ts.callCount++
+	ts.decodeRequest(call)
+	record := RecordedCall{
+		Method:     method,
+		Metadata:   md.Copy(),
+		Request:    call.request,
+		RawRequest: call.raw,
+		CallNumber: ts.callCount,
+		Timestamp:  receivedAt,
+	}
+	if call.request != nil {
+		if requestJSON, err := protojson.Marshal(call.request); err == nil {
+			record.RequestJSON = string(requestJSON)
+		}
+	}
+	matched := ts.matchStub(call)
+	if matched != nil {
+		record.Stub = matched.options.describe()
+	}
+	journalIndex := len(ts.journal)
+	ts.journal = append(ts.journal, record)
+	unmatchedCode := ts.options.unmatchedCode
+	ts.mux.Unlock()
+
+	var err error
+	if matched != nil {
+		err = matched.serve(stream, ts.closed)
+	} else {
+		err = status.Errorf(unmatchedCode, "no stub matched the call to %s", method)
+	}
+
+	ts.mux.Lock()
+	defer ts.mux.Unlock()
+	ts.journal[journalIndex].Code = status.Code(err).String()
+	ts.journal[journalIndex].Duration = time.Since(receivedAt)
+	return err
+}
+
+// matchStub returns the last added stub matching the call, the added stubs take precedence over the built-in stubs
+func (ts *mockGRPCServer) matchStub(call *incomingCall) *stub {
+	for _, stubs := range [][]*stub{ts.stubs, ts.options.builtInStubs} {
+		for i := len(stubs) - 1; i >= 0; i-- {
+			if stubs[i].match(call) {
+				return stubs[i]
+			}
+		}
+	}
+	return nil
+}
+
+// decodeRequest decodes the request message with the request type set on a stub of the method or the registered method descriptor
+func (ts *mockGRPCServer) decodeRequest(call *incomingCall) {
+	var requestType protoreflect.MessageDescriptor
+	for _, s := range append(append([]*stub{}, ts.stubs...), ts.options.builtInStubs...) {
+		if s.options.method == call.method && s.options.requestType != nil {
+			requestType = s.options.requestType
+			break
+		}
+	}
+	if requestType == nil {
+		descriptor, ok := lookupMethod(call.method)
+		if !ok {
+			return
+		}
+		requestType = descriptor.Input()
+	}
+	request := newMessage(requestType)
+	if err := proto.Unmarshal(call.raw, request); err != nil {
+		return
+	}
+	call.request = request
+}
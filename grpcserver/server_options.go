@@ -0,0 +1,105 @@
+package grpcserver
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+type ServerOption func(opts *serverOptions, isUpdate bool) error
+
+//Options
+
+// WithBuiltInStub option adds a built in stub to the server.
+// Built-in stubs are fixed and will not be removed when ResetStubs is called
+var WithBuiltInStub = func(opts ...StubOption) ServerOption {
+	return func(o *serverOptions, isUpdate bool) error {
+		if isUpdate {
+			return fmt.Errorf("built in stubs can't be updated")
+		}
+		stub, err := newStub(opts...)
+		if err != nil {
+			return err
+		}
+		o.builtInStubs = append(o.builtInStubs, stub)
+		return nil
+	}
+}
+
+// WithPort option sets the port for the server, 0 picks the next available port
+// This option cannot be changed after the server is created
+var WithPort = func(port int) ServerOption {
+	return func(o *serverOptions, isUpdate bool) error {
+		if isUpdate {
+			return fmt.Errorf("port can't be updated")
+		}
+		o.port = port
+		return nil
+	}
+}
+
+// WithHost option sets the address the server listens on, e.g. 0.0.0.0 to accept connections from other hosts
+// This option cannot be changed after the server is created
+var WithHost = func(host string) ServerOption {
+	return func(o *serverOptions, isUpdate bool) error {
+		if isUpdate {
+			return fmt.Errorf("host can't be updated")
+		}
+		if host == "" {
+			return fmt.Errorf("host must not be empty")
+		}
+		o.host = host
+		return nil
+	}
+}
+
+// WithUnmatchedCode option sets the status code of calls no stub matched, codes.Unimplemented by default
+var WithUnmatchedCode = func(code codes.Code) ServerOption {
+	return func(o *serverOptions, isUpdate bool) error {
+		if code == codes.OK {
+			return fmt.Errorf("unmatched code must be an error code")
+		}
+		o.unmatchedCode = code
+		return nil
+	}
+}
+
+// WithGRPCServerOptions option passes options to the underlying grpc server, e.g. credentials or interceptors
+// This option cannot be changed after the server is created
+var WithGRPCServerOptions = func(opts ...grpc.ServerOption) ServerOption {
+	return func(o *serverOptions, isUpdate bool) error {
+		if isUpdate {
+			return fmt.Errorf("grpc server options can't be updated")
+		}
+		o.grpcOptions = append(o.grpcOptions, opts...)
+		return nil
+	}
+}
+
+// Options for test server
+type serverOptions struct {
+	port          int
+	host          string
+	builtInStubs  []*stub
+	unmatchedCode codes.Code
+	grpcOptions   []grpc.ServerOption
+}
+
+func makeServerOptions(opts ...ServerOption) (*serverOptions, error) {
+	o := &serverOptions{
+		host:          localHost,
+		builtInStubs:  []*stub{},
+		unmatchedCode: codes.Unimplemented,
+	}
+	return applyOptions(o, false, opts...)
+}
+
+func applyOptions(o *serverOptions, isUpdate bool, opts ...ServerOption) (*serverOptions, error) {
+	for _, option := range opts {
+		if err := option(o, isUpdate); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
@@ -0,0 +1,156 @@
+package grpcserver
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	healthCheckMethod = "/grpc.health.v1.Health/Check"
+	healthWatchMethod = "/grpc.health.v1.Health/Watch"
+)
+
+func newHealthClient(t *testing.T, ts TestServer) healthpb.HealthClient {
+	t.Helper()
+	conn, err := grpc.Dial(ts.GetURL(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return healthpb.NewHealthClient(conn)
+}
+
+func TestUnaryJSONResponse(t *testing.T) {
+	ts := NewTestServerWithCleanup(t, WithBuiltInStub(WithMethod(healthCheckMethod), WithJSONResponse(`{"status": "SERVING"}`)))
+	client := newHealthClient(t, ts)
+	resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "elastic"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("expected status %s got %s", healthpb.HealthCheckResponse_SERVING, resp.Status)
+	}
+}
+
+func TestRequestMatching(t *testing.T) {
+	ts := NewTestServerWithCleanup(t, WithUnmatchedCode(codes.NotFound))
+	if err := ts.AddStub(WithMethod(healthCheckMethod), WithRequest(&healthpb.HealthCheckRequest{Service: "elastic"}),
+		WithResponse(&healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_NOT_SERVING})); err != nil {
+		t.Fatal(err)
+	}
+	client := newHealthClient(t, ts)
+	resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "elastic"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("expected status %s got %s", healthpb.HealthCheckResponse_NOT_SERVING, resp.Status)
+	}
+	if _, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "kafka"}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected the unmatched code %s got %v", codes.NotFound, err)
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	ts := NewTestServerWithCleanup(t)
+	if err := ts.AddStub(WithMethod(healthCheckMethod), WithError(codes.PermissionDenied, "not allowed")); err != nil {
+		t.Fatal(err)
+	}
+	client := newHealthClient(t, ts)
+	_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	st := status.Convert(err)
+	if st.Code() != codes.PermissionDenied || st.Message() != "not allowed" {
+		t.Errorf("expected status %s not allowed got %v", codes.PermissionDenied, err)
+	}
+}
+
+func TestServerStreamingResponses(t *testing.T) {
+	const interval = 50 * time.Millisecond
+	ts := NewTestServerWithCleanup(t)
+	if err := ts.AddStub(WithMethod(healthWatchMethod), WithJSONStreamResponses(interval, `{"status": "NOT_SERVING"}`, `{"status": "SERVING"}`, `{"status": "SERVICE_UNKNOWN"}`),
+		WithError(codes.Unavailable, "stream ended")); err != nil {
+		t.Fatal(err)
+	}
+	client := newHealthClient(t, ts)
+	stream, err := client.Watch(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []healthpb.HealthCheckResponse_ServingStatus{healthpb.HealthCheckResponse_NOT_SERVING, healthpb.HealthCheckResponse_SERVING, healthpb.HealthCheckResponse_SERVICE_UNKNOWN}
+	received := []time.Time{}
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			t.Fatal("expected the stream to end with the stub error")
+		}
+		if err != nil {
+			if status.Code(err) != codes.Unavailable {
+				t.Errorf("expected status %s got %v", codes.Unavailable, err)
+			}
+			break
+		}
+		if len(received) < len(expected) && resp.Status != expected[len(received)] {
+			t.Errorf("message %d: expected status %s got %s", len(received), expected[len(received)], resp.Status)
+		}
+		received = append(received, time.Now())
+	}
+	if len(received) != len(expected) {
+		t.Fatalf("expected %d messages got %d", len(expected), len(received))
+	}
+	for i := 1; i < len(received); i++ {
+		if gap := received[i].Sub(received[i-1]); gap < interval/2 {
+			t.Errorf("message %d received %s after the previous one, expected the interval %s", i, gap, interval)
+		}
+	}
+}
+
+func TestCallsJournal(t *testing.T) {
+	ts := NewTestServerWithCleanup(t, WithBuiltInStub(WithName("built-in"), WithMethod(healthCheckMethod), WithError(codes.Unavailable, "down")))
+	if err := ts.AddStub(WithName("serving"), WithMethod(healthCheckMethod), WithResponse(&healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING})); err != nil {
+		t.Fatal(err)
+	}
+	client := newHealthClient(t, ts)
+	request := &healthpb.HealthCheckRequest{Service: "elastic"}
+	if _, err := client.Check(context.Background(), request); err != nil {
+		t.Fatal(err)
+	}
+	ts.ResetStubs()
+	if _, err := client.Check(context.Background(), request); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected the built-in stub status %s after the reset got %v", codes.Unavailable, err)
+	}
+
+	calls := ts.FindCalls(healthCheckMethod)
+	if len(calls) != 2 || ts.GetCallCount() != 2 {
+		t.Fatalf("expected 2 calls got %d", len(calls))
+	}
+	for i, expected := range []struct {
+		stub string
+		code codes.Code
+	}{
+		{"serving", codes.OK},
+		{"built-in", codes.Unavailable},
+	} {
+		call := calls[i]
+		if call.CallNumber != i+1 || call.Stub != expected.stub || call.Code != expected.code.String() {
+			t.Errorf("call %d: expected stub %s and code %s got %+v", i+1, expected.stub, expected.code, call)
+		}
+		//protojson output is not stable, it is compared decoded
+		requestJSON := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(call.RequestJSON), &requestJSON); err != nil || requestJSON["service"] != "elastic" {
+			t.Errorf("call %d: unexpected request JSON %s", i+1, call.RequestJSON)
+		}
+		if !proto.Equal(call.Request, request) {
+			t.Errorf("call %d: unexpected request %v", i+1, call.Request)
+		}
+	}
+}
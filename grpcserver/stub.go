@@ -0,0 +1,99 @@
+package grpcserver
+
+import (
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// incomingCall is a call received by the server with its decoded request message
+type incomingCall struct {
+	method   string
+	metadata metadata.MD
+	raw      []byte
+	//request is nil if the request type of the method is unknown
+	request proto.Message
+}
+
+type stub struct {
+	options   *stubOptions
+	responses [][]byte
+}
+
+func newStub(opts ...StubOption) (*stub, error) {
+	options, err := makeStubOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+	responses, err := options.encodeResponses()
+	if err != nil {
+		return nil, err
+	}
+	return &stub{options: options, responses: responses}, nil
+}
+
+func (s *stub) match(call *incomingCall) bool {
+	if s.options.method != call.method {
+		return false
+	}
+	for _, matcher := range s.options.matchers {
+		if !matcher(call) {
+			return false
+		}
+	}
+	return true
+}
+
+// serve sends the headers, the response messages and the trailers of the stub and returns the status of the call
+// it stops early if the client cancels the call or the server is closed
+func (s *stub) serve(stream grpc.ServerStream, closed chan struct{}) error {
+	if err := wait(stream, closed, s.options.delay); err != nil {
+		return err
+	}
+	if len(s.options.headers) != 0 {
+		if err := stream.SetHeader(s.options.headers); err != nil {
+			return err
+		}
+	}
+	if len(s.options.trailers) != 0 {
+		stream.SetTrailer(s.options.trailers)
+	}
+	for i, response := range s.responses {
+		if i != 0 {
+			if err := wait(stream, closed, s.options.streamInterval); err != nil {
+				return err
+			}
+		}
+		if err := stream.SendMsg(&rawFrame{data: response}); err != nil {
+			return err
+		}
+	}
+	if s.options.status != nil && s.options.status.Code() != codes.OK {
+		return s.options.status.Err()
+	}
+	return nil
+}
+
+// wait waits for the duration, returns an error if the call ended or the server was closed before
+func wait(stream grpc.ServerStream, closed chan struct{}, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-stream.Context().Done():
+		return status.FromContextError(stream.Context().Err()).Err()
+	case <-closed:
+		return status.Error(codes.Unavailable, "server closed")
+	}
+}
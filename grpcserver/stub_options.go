@@ -0,0 +1,255 @@
+package grpcserver
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+type StubOption func(opts *stubOptions) error
+
+// WithMethod option sets the full method name the stub handles, e.g. /grpc.health.v1.Health/Check, the leading slash is optional
+var WithMethod = func(fullMethod string) StubOption {
+	return func(o *stubOptions) error {
+		if _, _, err := splitMethod(fullMethod); err != nil {
+			return err
+		}
+		o.method = "/" + strings.TrimPrefix(fullMethod, "/")
+		return nil
+	}
+}
+
+// WithName option sets a name for the stub, the name is used to identify the stub in the calls journal
+var WithName = func(name string) StubOption {
+	return func(o *stubOptions) error {
+		o.name = name
+		return nil
+	}
+}
+
+// WithRequestType option sets the request message type of the method, required to decode the requests of methods
+// whose generated code is not linked into the test binary
+var WithRequestType = func(prototype proto.Message) StubOption {
+	return func(o *stubOptions) error {
+		o.requestType = prototype.ProtoReflect().Descriptor()
+		return nil
+	}
+}
+
+// WithResponseType option sets the response message type of the method, required for JSON responses of methods
+// whose generated code is not linked into the test binary
+var WithResponseType = func(prototype proto.Message) StubOption {
+	return func(o *stubOptions) error {
+		o.responseType = prototype.ProtoReflect().Descriptor()
+		return nil
+	}
+}
+
+// WithRequest option matches calls whose request message equals expected
+var WithRequest = func(expected proto.Message) StubOption {
+	return func(o *stubOptions) error {
+		o.matchers = append(o.matchers, func(call *incomingCall) bool {
+			return call.request != nil && proto.Equal(call.request, expected)
+		})
+		return nil
+	}
+}
+
+// WithRequestPredicate option matches calls whose decoded request message satisfies the predicate
+var WithRequestPredicate = func(predicate func(request proto.Message) bool) StubOption {
+	return func(o *stubOptions) error {
+		o.matchers = append(o.matchers, func(call *incomingCall) bool {
+			return call.request != nil && predicate(call.request)
+		})
+		return nil
+	}
+}
+
+// WithMetadata option matches calls with the given metadata value
+var WithMetadata = func(key, value string) StubOption {
+	return func(o *stubOptions) error {
+		o.matchers = append(o.matchers, func(call *incomingCall) bool {
+			for _, v := range call.metadata.Get(key) {
+				if v == value {
+					return true
+				}
+			}
+			return false
+		})
+		return nil
+	}
+}
+
+// WithResponse option sets the response message of the stub
+var WithResponse = func(response proto.Message) StubOption {
+	return func(o *stubOptions) error {
+		o.responses = append(o.responses, stubResponse{message: response})
+		return nil
+	}
+}
+
+// WithJSONResponse option sets the response message of the stub from its protobuf JSON representation
+var WithJSONResponse = func(response string) StubOption {
+	return func(o *stubOptions) error {
+		o.responses = append(o.responses, stubResponse{json: response})
+		return nil
+	}
+}
+
+// WithStreamResponses option sets the messages of a server streaming response, each message is sent interval after the previous one
+var WithStreamResponses = func(interval time.Duration, responses ...proto.Message) StubOption {
+	return func(o *stubOptions) error {
+		o.streamInterval = interval
+		for _, response := range responses {
+			o.responses = append(o.responses, stubResponse{message: response})
+		}
+		return nil
+	}
+}
+
+// WithJSONStreamResponses option sets the messages of a server streaming response from their protobuf JSON representation
+var WithJSONStreamResponses = func(interval time.Duration, responses ...string) StubOption {
+	return func(o *stubOptions) error {
+		o.streamInterval = interval
+		for _, response := range responses {
+			o.responses = append(o.responses, stubResponse{json: response})
+		}
+		return nil
+	}
+}
+
+// WithError option ends the call with the status code and message, after the stream responses if set
+var WithError = func(code codes.Code, message string) StubOption {
+	return WithStatus(status.New(code, message))
+}
+
+// WithStatus option ends the call with the status, e.g. a status with details
+var WithStatus = func(st *status.Status) StubOption {
+	return func(o *stubOptions) error {
+		if st == nil {
+			return fmt.Errorf("status must not be nil")
+		}
+		o.status = st
+		return nil
+	}
+}
+
+// WithResponseHeaders option sets the header metadata sent to the client
+var WithResponseHeaders = func(md metadata.MD) StubOption {
+	return func(o *stubOptions) error {
+		o.headers = metadata.Join(o.headers, md)
+		return nil
+	}
+}
+
+// WithResponseTrailers option sets the trailer metadata sent to the client
+var WithResponseTrailers = func(md metadata.MD) StubOption {
+	return func(o *stubOptions) error {
+		o.trailers = metadata.Join(o.trailers, md)
+		return nil
+	}
+}
+
+// WithDelay option delays the response of the stub
+var WithDelay = func(delay time.Duration) StubOption {
+	return func(o *stubOptions) error {
+		if delay < 0 {
+			return fmt.Errorf("delay must not be negative")
+		}
+		o.delay = delay
+		return nil
+	}
+}
+
+// Options for a stub
+type stubOptions struct {
+	name           string
+	method         string
+	requestType    protoreflect.MessageDescriptor
+	responseType   protoreflect.MessageDescriptor
+	matchers       []func(call *incomingCall) bool
+	responses      []stubResponse
+	streamInterval time.Duration
+	status         *status.Status
+	headers        metadata.MD
+	trailers       metadata.MD
+	delay          time.Duration
+}
+
+// stubResponse is a response message or its JSON representation
+type stubResponse struct {
+	message proto.Message
+	json    string
+}
+
+func (o *stubOptions) validate() error {
+	if o.method == "" {
+		return fmt.Errorf("stub method must be set")
+	}
+	if len(o.responses) == 0 && o.status == nil {
+		return fmt.Errorf("stub %s must have a response or a status", o.describe())
+	}
+	return nil
+}
+
+// describe returns the stub name or its method if no name was set
+func (o *stubOptions) describe() string {
+	if o.name != "" {
+		return o.name
+	}
+	return o.method
+}
+
+// encodeResponses encodes the response messages, JSON responses are converted with the response type of the method
+func (o *stubOptions) encodeResponses() ([][]byte, error) {
+	encoded := make([][]byte, 0, len(o.responses))
+	for i, response := range o.responses {
+		message := response.message
+		if message == nil {
+			responseType := o.responseType
+			if responseType == nil {
+				descriptor, ok := lookupMethod(o.method)
+				if !ok {
+					return nil, fmt.Errorf("stub %s: response type of %s is unknown, set it with WithResponseType", o.describe(), o.method)
+				}
+				responseType = descriptor.Output()
+			}
+			message = newMessage(responseType)
+			if err := protojson.Unmarshal([]byte(response.json), message); err != nil {
+				return nil, fmt.Errorf("stub %s: invalid JSON response %d: %v", o.describe(), i, err)
+			}
+		}
+		data, err := proto.Marshal(message)
+		if err != nil {
+			return nil, fmt.Errorf("stub %s: failed to encode response %d: %v", o.describe(), i, err)
+		}
+		encoded = append(encoded, data)
+	}
+	return encoded, nil
+}
+
+func makeStubOptions(opts ...StubOption) (*stubOptions, error) {
+	o := &stubOptions{}
+	for _, option := range opts {
+		if err := option(o); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// splitMethod splits a full method name into the service and method names
+func splitMethod(fullMethod string) (string, string, error) {
+	parts := strings.Split(strings.TrimPrefix(fullMethod, "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid method %s, expected /package.Service/Method", fullMethod)
+	}
+	return parts[0], parts[1], nil
+}
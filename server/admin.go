@@ -0,0 +1,173 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+)
+
+// The admin API manages the server from other processes, it is enabled with WithAdminAPI or WithAdminPort:
+//
+//	POST   /stubs            add stubs, the body is a stub, a list of stubs or a stub file in JSON (see the README)
+//	GET    /stubs            list the built in and added stubs
+//	DELETE /stubs/{id}       remove a stub
+//	POST   /reset            remove the added stubs and reset the scenarios, like ResetHandlers and ResetScenarios
+//	GET    /requests         the requests journal, like GetRequests
+//	GET    /requests/count   the number of requests received, like GetRequestCount
+
+type adminStub struct {
+	ID      int64  `json:"id"`
+	Name    string `json:"name"`
+	BuiltIn bool   `json:"builtIn"`
+	Hits    int    `json:"hits"`
+}
+
+type adminAddStubsResponse struct {
+	IDs []int64 `json:"ids"`
+}
+
+type adminCountResponse struct {
+	Count int `json:"count"`
+}
+
+type adminErrorResponse struct {
+	Error string `json:"error"`
+}
+
+func (ts *mockTestingServer) GetAdminURL() string {
+	switch {
+	case ts.adminServer != nil:
+		return ts.adminServer.URL
+	case ts.options.adminPrefix != "":
+		return ts.GetURL() + ts.options.adminPrefix
+	}
+	return ""
+}
+
+func (ts *mockTestingServer) startAdminServer() error {
+	if ts.options.adminPort == nil {
+		return nil
+	}
+	l, err := net.Listen("tcp", fmt.Sprintf("%s:%d", ts.options.host, *ts.options.adminPort))
+	if err != nil {
+		return err
+	}
+	ts.adminServer = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ts.serveAdmin(w, r, r.URL.Path)
+	}))
+	if err := ts.adminServer.Listener.Close(); err != nil {
+		l.Close()
+		return err
+	}
+	ts.adminServer.Listener = l
+	ts.adminServer.Start()
+	return nil
+}
+
+// serveAdmin serves an admin API request, adminPath is the request path without the admin prefix
+func (ts *mockTestingServer) serveAdmin(w http.ResponseWriter, r *http.Request, adminPath string) {
+	adminPath = strings.TrimSuffix(adminPath, "/")
+	switch {
+	case adminPath == "/stubs" && r.Method == http.MethodGet:
+		writeAdminJSON(w, http.StatusOK, ts.listStubs())
+	case adminPath == "/stubs" && r.Method == http.MethodPost:
+		ts.adminAddStubs(w, r)
+	case strings.HasPrefix(adminPath, "/stubs/") && r.Method == http.MethodDelete:
+		id, err := strconv.ParseInt(strings.TrimPrefix(adminPath, "/stubs/"), 10, 64)
+		if err != nil {
+			writeAdminJSON(w, http.StatusBadRequest, &adminErrorResponse{Error: fmt.Sprintf("invalid stub id: %v", err)})
+			return
+		}
+		if !ts.removeHandler(id) {
+			writeAdminJSON(w, http.StatusNotFound, &adminErrorResponse{Error: fmt.Sprintf("stub %d not found", id)})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case adminPath == "/reset" && r.Method == http.MethodPost:
+		ts.ResetHandlers()
+		ts.ResetScenarios()
+		w.WriteHeader(http.StatusNoContent)
+	case adminPath == "/requests" && r.Method == http.MethodGet:
+		writeAdminJSON(w, http.StatusOK, ts.GetRequests())
+	case adminPath == "/requests/count" && r.Method == http.MethodGet:
+		writeAdminJSON(w, http.StatusOK, &adminCountResponse{Count: ts.GetRequestCount()})
+	case adminPath == "/stubs" || strings.HasPrefix(adminPath, "/stubs/") || adminPath == "/reset" || strings.HasPrefix(adminPath, "/requests"):
+		writeAdminJSON(w, http.StatusMethodNotAllowed, &adminErrorResponse{Error: fmt.Sprintf("method %s is not allowed for %s", r.Method, adminPath)})
+	default:
+		writeAdminJSON(w, http.StatusNotFound, &adminErrorResponse{Error: fmt.Sprintf("unknown admin endpoint %s", adminPath)})
+	}
+}
+
+func (ts *mockTestingServer) adminAddStubs(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeAdminJSON(w, http.StatusBadRequest, &adminErrorResponse{Error: err.Error()})
+		return
+	}
+	stubs, err := parseStubs(body, "request body", nil, "")
+	if err != nil {
+		writeAdminJSON(w, http.StatusBadRequest, &adminErrorResponse{Error: err.Error()})
+		return
+	}
+	response := adminAddStubsResponse{IDs: []int64{}}
+	for _, opts := range stubs {
+		handler, err := ts.addHandler(opts...)
+		if err != nil {
+			writeAdminJSON(w, http.StatusBadRequest, &adminErrorResponse{Error: err.Error()})
+			return
+		}
+		response.IDs = append(response.IDs, handler.id)
+	}
+	writeAdminJSON(w, http.StatusCreated, &response)
+}
+
+func (ts *mockTestingServer) listStubs() []adminStub {
+	ts.mux.Lock()
+	defer ts.mux.Unlock()
+	ts.handlersMux.RLock()
+	defer ts.handlersMux.RUnlock()
+	stubs := []adminStub{}
+	for _, handler := range ts.options.defaultRequestHandlers {
+		stubs = append(stubs, adminStub{ID: handler.id, Name: handler.options.describe(), BuiltIn: true, Hits: handler.hits})
+	}
+	for _, handler := range ts.requestHandlers {
+		stubs = append(stubs, adminStub{ID: handler.id, Name: handler.options.describe(), Hits: handler.hits})
+	}
+	return stubs
+}
+
+// removeHandler removes a built in or added handler by id, returns false if the handler was not found
+func (ts *mockTestingServer) removeHandler(id int64) bool {
+	ts.mux.Lock()
+	defer ts.mux.Unlock()
+	ts.handlersMux.Lock()
+	defer ts.handlersMux.Unlock()
+	var found bool
+	ts.options.defaultRequestHandlers, found = removeHandlerByID(ts.options.defaultRequestHandlers, id)
+	if found {
+		return true
+	}
+	ts.requestHandlers, found = removeHandlerByID(ts.requestHandlers, id)
+	return found
+}
+
+func removeHandlerByID(handlers []*serverRequestHandler, id int64) ([]*serverRequestHandler, bool) {
+	for i, handler := range handlers {
+		if handler.id == id {
+			return append(append([]*serverRequestHandler{}, handlers[:i]...), handlers[i+1:]...), true
+		}
+	}
+	return handlers, false
+}
+
+func writeAdminJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	body, _ := json.Marshal(v)
+	w.Header().Set(contentTypeHeader, jsonContentType)
+	w.WriteHeader(statusCode)
+	w.Write(body)
+}
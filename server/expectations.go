@@ -0,0 +1,106 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const (
+	unlimitedCalls = -1
+	// maximum number of unmatched requests reported for each unmet expectation
+	maxReportedRequests = 3
+)
+
+// callExpectation is the number of times a handler is expected to be called
+type callExpectation struct {
+	min int
+	max int
+}
+
+func (e *callExpectation) met(hits int) bool {
+	return hits >= e.min && (e.max == unlimitedCalls || hits <= e.max)
+}
+
+func (e *callExpectation) String() string {
+	switch {
+	case e.min == e.max:
+		return fmt.Sprintf("exactly %d times", e.min)
+	case e.max == unlimitedCalls:
+		return fmt.Sprintf("at least %d times", e.min)
+	default:
+		return fmt.Sprintf("at most %d times", e.max)
+	}
+}
+
+// Verify reports an error on t for each handler expectation that was not met and each failed websocket script
+// handlers removed by ResetHandlers are not verified
+func (ts *mockTestingServer) Verify(t *testing.T) bool {
+	type verifiedHandler struct {
+		options *requestHandlerOptions
+		hits    int
+	}
+	//the hits and the journal are copied so the matchers run without holding the server locks
+	ts.mux.Lock()
+	ts.handlersMux.RLock()
+	handlers := []verifiedHandler{}
+	for _, handler := range append(append([]*serverRequestHandler{}, ts.options.defaultRequestHandlers...), ts.requestHandlers...) {
+		handlers = append(handlers, verifiedHandler{options: handler.options, hits: handler.hits})
+	}
+	journal := copyRecordedRequests(ts.journal)
+	ts.handlersMux.RUnlock()
+	ts.mux.Unlock()
+
+	ok := true
+	for _, handler := range handlers {
+		if ws := handler.options.webSocket; ws != nil {
+			for _, err := range ws.Errors() {
+				ok = false
+				t.Errorf("handler %s: %v", handler.options.describe(), err)
+			}
+		}
+		expectation := handler.options.expectation
+		if expectation == nil || expectation.met(handler.hits) {
+			continue
+		}
+		ok = false
+		msg := fmt.Sprintf("handler %s expected to be called %s but was called %d times", handler.options.describe(), expectation, handler.hits)
+		if handler.hits < expectation.min {
+			if closest := closestUnmatchedRequests(handler.options, journal); len(closest) != 0 {
+				msg += "\nclosest unmatched requests:\n\t" + strings.Join(closest, "\n\t")
+			}
+		}
+		t.Error(msg)
+	}
+	return ok
+}
+
+// closestUnmatchedRequests returns the descriptions of the requests that were not handled by any handler ordered by the number of failed matchers
+func closestUnmatchedRequests(options *requestHandlerOptions, journal []RecordedRequest) []string {
+	type candidate struct {
+		request    RecordedRequest
+		mismatches []string
+	}
+	candidates := []candidate{}
+	for _, request := range journal {
+		if len(request.Handlers) != 0 {
+			continue
+		}
+		candidates = append(candidates, candidate{
+			request:    request,
+			mismatches: options.mismatches(request.toHTTPRequest(), request.Body, request.RequestNumber),
+		})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return len(candidates[i].mismatches) < len(candidates[j].mismatches)
+	})
+	if len(candidates) > maxReportedRequests {
+		candidates = candidates[:maxReportedRequests]
+	}
+	descriptions := []string{}
+	for _, c := range candidates {
+		descriptions = append(descriptions, fmt.Sprintf("#%d %s %s (%s)", c.request.RequestNumber, c.request.Method, c.request.URL, strings.Join(c.mismatches, ", ")))
+	}
+	return descriptions
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// FaultType is the kind of failure injected into a response
+type FaultType string
+
+const (
+	// FaultDelay delays the response
+	FaultDelay FaultType = "delay"
+	// FaultConnectionReset closes the connection abruptly without writing a response
+	FaultConnectionReset FaultType = "connection_reset"
+	// FaultTruncatedBody writes only the first half of the body while declaring the full content length and closes the connection
+	FaultTruncatedBody FaultType = "truncated_body"
+	// FaultMalformedBody replaces the second half of the body with garbage
+	FaultMalformedBody FaultType = "malformed_body"
+	// FaultHang never responds, the request is held until the client gives up or the server is closed
+	FaultHang FaultType = "hang"
+)
+
+// malformedBodySuffix is appended to the truncated body of a FaultMalformedBody response
+const malformedBodySuffix = "\x00<malformed>"
+
+// Fault is a failure injected into the server responses, see the Fault constructors for the supported faults
+type Fault struct {
+	Type FaultType
+	//Delay is the fixed delay of a FaultDelay
+	Delay time.Duration
+	//Jitter is the maximum random delay added to Delay
+	Jitter time.Duration
+	//Rate is the probability in the range (0,1] for the fault to be applied to a request
+	Rate float64
+}
+
+// DelayFault delays the response by delay
+func DelayFault(delay time.Duration) Fault {
+	return Fault{Type: FaultDelay, Delay: delay, Rate: 1}
+}
+
+// JitteredDelayFault delays the response by a random duration between minDelay and maxDelay
+func JitteredDelayFault(minDelay, maxDelay time.Duration) Fault {
+	return Fault{Type: FaultDelay, Delay: minDelay, Jitter: maxDelay - minDelay, Rate: 1}
+}
+
+// ConnectionResetFault closes the connection without writing a response
+func ConnectionResetFault() Fault {
+	return Fault{Type: FaultConnectionReset, Rate: 1}
+}
+
+// TruncatedBodyFault closes the connection after writing half of the response body
+func TruncatedBodyFault() Fault {
+	return Fault{Type: FaultTruncatedBody, Rate: 1}
+}
+
+// MalformedBodyFault corrupts the response body
+func MalformedBodyFault() Fault {
+	return Fault{Type: FaultMalformedBody, Rate: 1}
+}
+
+// HangFault holds the request until the client gives up
+func HangFault() Fault {
+	return Fault{Type: FaultHang, Rate: 1}
+}
+
+// WithRate returns a copy of the fault that is applied with probability rate, using the server random source (see WithRandomSeed)
+func (f Fault) WithRate(rate float64) Fault {
+	f.Rate = rate
+	return f
+}
+
+func (f *Fault) validate() error {
+	switch f.Type {
+	case FaultDelay:
+		if f.Delay < 0 || f.Jitter < 0 {
+			return fmt.Errorf("fault delay must not be negative")
+		}
+	case FaultConnectionReset, FaultTruncatedBody, FaultMalformedBody, FaultHang:
+	default:
+		return fmt.Errorf("unknown fault type %q", f.Type)
+	}
+	if f.Rate <= 0 || f.Rate > 1 {
+		return fmt.Errorf("fault rate must be in the range (0,1], got %v", f.Rate)
+	}
+	return nil
+}
+
+func (f *Fault) isTerminal() bool {
+	return f.Type != FaultDelay
+}
+
+func (f *Fault) String() string {
+	if f.Type == FaultDelay {
+		return fmt.Sprintf("%s(%s)", f.Type, f.Delay)
+	}
+	return string(f.Type)
+}
+
+func validateFaults(faults []Fault) error {
+	for i := range faults {
+		if err := faults[i].validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pickFaults draws the faults applied to a request out of the candidates, delays are resolved to a fixed duration
+// and only the first drawn terminal fault is kept
+func pickFaults(rng *rand.Rand, candidates []Fault) []Fault {
+	picked := []Fault{}
+	hasTerminal := false
+	for _, fault := range candidates {
+		if fault.Rate < 1 && rng.Float64() >= fault.Rate {
+			continue
+		}
+		if fault.isTerminal() {
+			if hasTerminal {
+				continue
+			}
+			hasTerminal = true
+		}
+		if fault.Jitter > 0 {
+			fault.Delay += time.Duration(rng.Int63n(int64(fault.Jitter) + 1))
+			fault.Jitter = 0
+		}
+		picked = append(picked, fault)
+	}
+	return picked
+}
+
+func faultNames(faults []Fault) []string {
+	if len(faults) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(faults))
+	for i := range faults {
+		names = append(names, faults[i].String())
+	}
+	return names
+}
+
+// applyDelays sleeps for the total delay of the faults, returns false if ctx or closed were done before the delay passed
+func applyDelays(ctx context.Context, closed <-chan struct{}, faults []Fault) bool {
+	var delay time.Duration
+	for _, fault := range faults {
+		if fault.Type == FaultDelay {
+			delay += fault.Delay
+		}
+	}
+	if delay == 0 {
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-closed:
+		return false
+	}
+}
+
+func terminalFault(faults []Fault) *Fault {
+	for i := range faults {
+		if faults[i].isTerminal() {
+			return &faults[i]
+		}
+	}
+	return nil
+}
+
+// resetConnection closes the client connection abruptly, without writing a response
+func resetConnection(w http.ResponseWriter) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		// abort the handler, the server closes the connection (or resets the stream for HTTP/2)
+		panic(http.ErrAbortHandler)
+	}
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	if tcpConn, ok := underlyingConn(conn).(*net.TCPConn); ok {
+		// discard unsent data and send RST instead of FIN
+		tcpConn.SetLinger(0)
+	}
+	conn.Close()
+}
+
+func underlyingConn(conn net.Conn) net.Conn {
+	if netConner, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		return netConner.NetConn()
+	}
+	return conn
+}
+
+// bufferedResponseWriter holds the response written by the handlers so that a body fault can be applied to it
+type bufferedResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (bw *bufferedResponseWriter) WriteHeader(statusCode int) {
+	if bw.statusCode == 0 {
+		bw.statusCode = statusCode
+	}
+}
+
+func (bw *bufferedResponseWriter) Write(b []byte) (int, error) {
+	return bw.body.Write(b)
+}
+
+// writeWithFault writes the buffered response to the underlying writer after applying the body fault
+func (bw *bufferedResponseWriter) writeWithFault(fault *Fault) {
+	body := bw.body.Bytes()
+	header := bw.ResponseWriter.Header()
+	switch fault.Type {
+	case FaultTruncatedBody:
+		declaredLength := len(body)
+		if declaredLength == 0 {
+			// declare at least one byte so the client notices the missing data of an empty body as well
+			declaredLength = 1
+		}
+		header.Set("Content-Length", strconv.Itoa(declaredLength))
+		body = body[:len(body)/2]
+	case FaultMalformedBody:
+		body = append(append([]byte{}, body[:len(body)/2]...), malformedBodySuffix...)
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	if bw.statusCode != 0 {
+		bw.ResponseWriter.WriteHeader(bw.statusCode)
+	}
+	bw.ResponseWriter.Write(body)
+}
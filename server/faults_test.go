@@ -0,0 +1,73 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// newFaultsClient returns a client that does not reuse connections, so a connection torn down by a fault is not retried
+func newFaultsClient() *http.Client {
+	return &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+}
+
+func TestConnectionResetFault(t *testing.T) {
+	ts := NewTestServerWithCleanup(t)
+	if _, err := ts.AddHandler(WithPath("/reset"), WithResponse([]byte("never sent")), WithFault(ConnectionResetFault())); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := newFaultsClient().Get(ts.GetURL() + "/reset")
+	if err == nil {
+		resp.Body.Close()
+		t.Fatalf("expected the connection to be reset got status %d", resp.StatusCode)
+	}
+	if !errors.Is(err, syscall.ECONNRESET) && !errors.Is(err, io.EOF) {
+		t.Errorf("expected a connection reset or EOF got %v", err)
+	}
+	requests := ts.GetRequests()
+	if len(requests) != 1 || !reflect.DeepEqual(requests[0].Faults, []string{string(FaultConnectionReset)}) {
+		t.Errorf("expected the fault to be journaled got %v", requests)
+	}
+}
+
+func TestTruncatedBodyFault(t *testing.T) {
+	body := []byte(strings.Repeat("0123456789", 10))
+	ts := NewTestServerWithCleanup(t)
+	if _, err := ts.AddHandler(WithPath("/truncated"), WithResponse(body), WithFault(TruncatedBodyFault())); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := newFaultsClient().Get(ts.GetURL() + "/truncated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.ContentLength != int64(len(body)) {
+		t.Errorf("expected content length %d got %d", len(body), resp.ContentLength)
+	}
+	received, err := ioutil.ReadAll(resp.Body)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected an unexpected EOF reading the body got %v", err)
+	}
+	if int64(len(received)) >= resp.ContentLength {
+		t.Errorf("expected a body shorter than the content length %d got %d bytes", resp.ContentLength, len(received))
+	}
+	if string(received) != string(body[:len(body)/2]) {
+		t.Errorf("expected the first half of the body got %s", received)
+	}
+}
+
+func TestMalformedBodyFault(t *testing.T) {
+	ts := NewTestServerWithCleanup(t)
+	if _, err := ts.AddHandler(WithPath("/malformed"), WithJSONResponse(map[string]bool{"found": true}), WithFault(MalformedBodyFault())); err != nil {
+		t.Fatal(err)
+	}
+	body := string(doRequest(t, http.MethodGet, ts.GetURL()+"/malformed").body)
+	if !strings.HasSuffix(body, malformedBodySuffix) || strings.HasSuffix(body, "}"+malformedBodySuffix) {
+		t.Errorf("expected the second half of the body to be replaced got %q", body)
+	}
+}
@@ -1,9 +1,14 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"reflect"
+	"regexp"
 	"testing"
+	"time"
 
 	"github.com/armosec/ca-test/utils"
 	"github.com/google/go-cmp/cmp"
@@ -27,6 +32,9 @@ var WithPath = func(path string) RequestHandlerOption {
 		if path != "" && (o.pathPrefix != "" || o.pathSuffix != "") {
 			return fmt.Errorf("path can't be set with path prefix or suffix")
 		}
+		if path != "" && o.pathPattern != nil {
+			return fmt.Errorf("path can't be set with %s", o.pathPattern.kind)
+		}
 		o.path = path
 		return nil
 	}
@@ -38,6 +46,9 @@ var WithPathPrefix = func(pathPrefix string) RequestHandlerOption {
 		if pathPrefix != "" && o.path != "" {
 			return fmt.Errorf("path and path prefix can't be set together")
 		}
+		if pathPrefix != "" && o.pathPattern != nil {
+			return fmt.Errorf("path prefix can't be set with %s", o.pathPattern.kind)
+		}
 		o.pathPrefix = pathPrefix
 		return nil
 	}
@@ -49,11 +60,160 @@ var WithPathSuffix = func(pathSuffix string) RequestHandlerOption {
 		if pathSuffix != "" && o.path != "" {
 			return fmt.Errorf("path and path suffix can't be set together")
 		}
+		if pathSuffix != "" && o.pathPattern != nil {
+			return fmt.Errorf("path suffix can't be set with %s", o.pathPattern.kind)
+		}
 		o.pathSuffix = pathSuffix
 		return nil
 	}
 }
 
+// WithPathTemplate option sets a path template for the handler, each {name} matches a single path segment, e.g. /{index}/_doc/{id}
+// the captured parameters are available to the handler with PathParams and PathParam
+var WithPathTemplate = func(template string) RequestHandlerOption {
+	return withPathPattern(template, newPathTemplate)
+}
+
+// WithPathGlob option sets a glob pattern for the handler path, using the syntax of path.Match, e.g. /*/_settings
+var WithPathGlob = func(glob string) RequestHandlerOption {
+	return withPathPattern(glob, newPathGlob)
+}
+
+// WithPathRegex option sets a regular expression that must match the entire handler path
+// named groups are captured as parameters and are available to the handler with PathParams and PathParam
+var WithPathRegex = func(expr string) RequestHandlerOption {
+	return withPathPattern(expr, newPathRegex)
+}
+
+func withPathPattern(pattern string, compile func(string) (*pathPattern, error)) RequestHandlerOption {
+	return func(o *requestHandlerOptions) error {
+		if o.path != "" || o.pathPrefix != "" || o.pathSuffix != "" {
+			return fmt.Errorf("path pattern can't be set with path, path prefix or suffix")
+		}
+		if o.pathPattern != nil {
+			return fmt.Errorf("path pattern can't be set with %s", o.pathPattern.kind)
+		}
+		p, err := compile(pattern)
+		if err != nil {
+			return err
+		}
+		o.pathPattern = p
+		return nil
+	}
+}
+
+// WithQueryParam option matches requests having the query parameter key with the value value
+var WithQueryParam = func(key, value string) RequestHandlerOption {
+	return func(o *requestHandlerOptions) error {
+		o.matchers = append(o.matchers, queryParamMatcher(key, value))
+		return nil
+	}
+}
+
+// WithQueryParamPresent option matches requests having the query parameter key with any value
+var WithQueryParamPresent = func(key string) RequestHandlerOption {
+	return func(o *requestHandlerOptions) error {
+		o.matchers = append(o.matchers, queryParamPresentMatcher(key))
+		return nil
+	}
+}
+
+// WithRequestHeader option matches requests having the header key with the value value
+var WithRequestHeader = func(key, value string) RequestHandlerOption {
+	return func(o *requestHandlerOptions) error {
+		o.matchers = append(o.matchers, headerMatcher(key, value))
+		return nil
+	}
+}
+
+// WithRequestHeaderRegex option matches requests having the header key with a value matching the regular expression expr
+var WithRequestHeaderRegex = func(key, expr string) RequestHandlerOption {
+	return func(o *requestHandlerOptions) error {
+		regex, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("invalid header %s regex: %v", key, err)
+		}
+		o.matchers = append(o.matchers, requestMatcher{
+			description: fmt.Sprintf("header %s: expected to match %s", key, expr),
+			match: func(r *http.Request, reqBody string) bool {
+				for _, v := range r.Header.Values(key) {
+					if regex.MatchString(v) {
+						return true
+					}
+				}
+				return false
+			},
+		})
+		return nil
+	}
+}
+
+// WithJSONBody option matches requests with a JSON body containing partial, objects in the body may have additional keys
+// and each element of an array in partial must match an element of the body array
+var WithJSONBody = func(partial interface{}) RequestHandlerOption {
+	return func(o *requestHandlerOptions) error {
+		expected, err := normalizeJSON(partial)
+		if err != nil {
+			return fmt.Errorf("invalid JSON body matcher: %v", err)
+		}
+		o.matchers = append(o.matchers, jsonBodyMatcher("JSON body: expected to contain the partial body", func(body interface{}) bool {
+			return jsonContains(body, expected)
+		}))
+		return nil
+	}
+}
+
+// WithJSONPath option matches requests with a JSON body that has a value at path for which predicate returns true
+// path supports fields and array indexes, e.g. $.query.bool.must[0].term
+var WithJSONPath = func(path string, predicate func(value interface{}) bool) RequestHandlerOption {
+	return func(o *requestHandlerOptions) error {
+		if _, err := parseJSONPath(path); err != nil {
+			return err
+		}
+		if predicate == nil {
+			return fmt.Errorf("json path %s predicate must be provided", path)
+		}
+		o.matchers = append(o.matchers, jsonBodyMatcher(fmt.Sprintf("JSON path %s: predicate failed", path), func(body interface{}) bool {
+			value, ok := jsonPathLookup(body, path)
+			return ok && predicate(value)
+		}))
+		return nil
+	}
+}
+
+// WithJSONPathValue option matches requests with a JSON body that has the value expected at path, see WithJSONPath for the path syntax
+var WithJSONPathValue = func(path string, expected interface{}) RequestHandlerOption {
+	return func(o *requestHandlerOptions) error {
+		normalized, err := normalizeJSON(expected)
+		if err != nil {
+			return fmt.Errorf("invalid json path %s value: %v", path, err)
+		}
+		if err := WithJSONPath(path, func(value interface{}) bool {
+			return reflect.DeepEqual(value, normalized)
+		})(o); err != nil {
+			return err
+		}
+		o.matchers[len(o.matchers)-1].description = fmt.Sprintf("JSON path %s: expected %v", path, expected)
+		return nil
+	}
+}
+
+// WithBodyPredicate option matches requests with a body for which predicate returns true
+var WithBodyPredicate = func(predicate func(body string) bool) RequestHandlerOption {
+	return func(o *requestHandlerOptions) error {
+		if predicate == nil {
+			return fmt.Errorf("body predicate must be provided")
+		}
+		o.matchers = append(o.matchers, requestMatcher{
+			description: "body: predicate failed",
+			match: func(r *http.Request, reqBody string) bool {
+				return predicate(reqBody)
+			},
+		})
+		return nil
+	}
+}
+
 // WithResponse option sets the response for the handler
 var WithResponse = func(response []byte) RequestHandlerOption {
 	return func(o *requestHandlerOptions) error {
@@ -65,12 +225,168 @@ var WithResponse = func(response []byte) RequestHandlerOption {
 	}
 }
 
+// WithResponses option sets a sequence of responses for the handler, each request is answered with the next response
+// and the handler stops matching requests once all the responses were served
 var WithResponses = func(responses [][]byte) RequestHandlerOption {
+	if responses == nil {
+		return WithStatusResponses(nil)
+	}
+	statusResponses := make([]Response, 0, len(responses))
+	for _, response := range responses {
+		statusResponses = append(statusResponses, Response{Body: response})
+	}
+	return WithStatusResponses(statusResponses)
+}
+
+// WithStatusResponses option sets a sequence of responses with status codes and headers for the handler,
+// each request is answered with the next response and the handler stops matching requests once all the responses were served
+var WithStatusResponses = func(responses []Response) RequestHandlerOption {
 	return func(o *requestHandlerOptions) error {
 		if len(responses) != 0 && (o.handler != nil || len(o.response) != 0) {
 			return fmt.Errorf("responses can't be set with handler or with fixed response")
 		}
-		o.responses = responses
+		if responses == nil {
+			o.responses = nil
+			return nil
+		}
+		o.responses = make([]Response, 0, len(responses))
+		for i, response := range responses {
+			if err := response.prepare(); err != nil {
+				return fmt.Errorf("response %d: %v", i, err)
+			}
+			o.responses = append(o.responses, response)
+		}
+		return nil
+	}
+}
+
+// WithJSONResponse option sets the response for the handler to the JSON encoding of v
+var WithJSONResponse = func(v interface{}) RequestHandlerOption {
+	return func(o *requestHandlerOptions) error {
+		response, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("failed to encode JSON response: %v", err)
+		}
+		if err := WithResponse(response)(o); err != nil {
+			return err
+		}
+		return WithResponseHeaders(map[string]string{contentTypeHeader: jsonContentType})(o)
+	}
+}
+
+// WithChunkedResponse option streams the chunks as the response body, each chunk is flushed to the client and written interval after the previous one
+var WithChunkedResponse = func(interval time.Duration, chunks ...[]byte) RequestHandlerOption {
+	return withStream(timedStream(streamContentType, interval, chunks))
+}
+
+// WithSSEResponse option streams the events as Server-Sent Events, each event is written interval after the previous one
+var WithSSEResponse = func(interval time.Duration, events ...SSEEvent) RequestHandlerOption {
+	return withStream(timedStream(sseContentType, interval, sseEvents(events)))
+}
+
+// WithNDJSONResponse option streams the JSON encoding of the values as newline delimited JSON, each line is written interval after the previous one
+var WithNDJSONResponse = func(interval time.Duration, values ...interface{}) RequestHandlerOption {
+	lines := make([][]byte, 0, len(values))
+	for _, v := range values {
+		line, err := ndjsonLine(v)
+		if err != nil {
+			return func(o *requestHandlerOptions) error {
+				return err
+			}
+		}
+		lines = append(lines, line)
+	}
+	return withStream(timedStream(ndjsonContentType, interval, lines))
+}
+
+// WithStreamResponse option streams the chunks received from the channel as the response body until the channel is closed,
+// requests served concurrently by the handler share the channel
+var WithStreamResponse = func(chunks <-chan []byte) RequestHandlerOption {
+	return withStream(channelStream(streamContentType, func(ctx context.Context) ([]byte, bool) {
+		select {
+		case chunk, ok := <-chunks:
+			return chunk, ok
+		case <-ctx.Done():
+			return nil, false
+		}
+	}))
+}
+
+// WithSSEStream option streams the events received from the channel as Server-Sent Events until the channel is closed
+var WithSSEStream = func(events <-chan SSEEvent) RequestHandlerOption {
+	return withStream(channelStream(sseContentType, func(ctx context.Context) ([]byte, bool) {
+		select {
+		case event, ok := <-events:
+			return event.encode(), ok
+		case <-ctx.Done():
+			return nil, false
+		}
+	}))
+}
+
+// WithNDJSONStream option streams the JSON encoding of the values received from the channel as newline delimited JSON until the channel is closed
+var WithNDJSONStream = func(values <-chan interface{}) RequestHandlerOption {
+	return withStream(channelStream(ndjsonContentType, func(ctx context.Context) ([]byte, bool) {
+		select {
+		case v, ok := <-values:
+			if !ok {
+				return nil, false
+			}
+			line, err := ndjsonLine(v)
+			return line, err == nil
+		case <-ctx.Done():
+			return nil, false
+		}
+	}))
+}
+
+func withStream(stream *responseStream) RequestHandlerOption {
+	return func(o *requestHandlerOptions) error {
+		if o.handler != nil || len(o.response) != 0 || len(o.responses) != 0 {
+			return fmt.Errorf("streamed response can't be set with handler, response or responses array")
+		}
+		o.stream = stream
+		return nil
+	}
+}
+
+// WithResponseTemplate option sets the response for the handler to a text/template rendered with the TemplateData of each request,
+// e.g. {"id": "{{.PathParams.id}}", "name": {{json .JSON.name}}, "requestId": "{{uuid}}"}.
+// The helpers uuid, now [layout], counter name, json value and jsonPath value path are available in the template
+var WithResponseTemplate = func(responseTemplate string) RequestHandlerOption {
+	return func(o *requestHandlerOptions) error {
+		if o.handler != nil || len(o.response) != 0 {
+			return fmt.Errorf("response template can't be set with handler or with fixed response")
+		}
+		tmpl, err := parseResponseTemplate(responseTemplate)
+		if err != nil {
+			return err
+		}
+		o.template = tmpl
+		return nil
+	}
+}
+
+// WithStatusCode option sets the response status code for the handler
+var WithStatusCode = func(statusCode int) RequestHandlerOption {
+	return func(o *requestHandlerOptions) error {
+		if statusCode != 0 && (statusCode < 100 || statusCode > 999) {
+			return fmt.Errorf("invalid status code %d", statusCode)
+		}
+		o.statusCode = statusCode
+		return nil
+	}
+}
+
+// WithResponseHeaders option adds headers to the handler responses, the headers override the server headers set by WithHeaders
+var WithResponseHeaders = func(headers map[string]string) RequestHandlerOption {
+	return func(o *requestHandlerOptions) error {
+		if o.responseHeaders == nil {
+			o.responseHeaders = map[string]string{}
+		}
+		for k, v := range headers {
+			o.responseHeaders[k] = v
+		}
 		return nil
 	}
 }
@@ -86,7 +402,32 @@ var WithHandler = func(handler RequestHandler) RequestHandlerOption {
 	}
 }
 
-// WithRequestNumber option sets the request number for the handler
+// WithWebSocket option serves WebSocket connections with the mock, requests that are not WebSocket upgrade requests are not matched
+var WithWebSocket = func(ws *WebSocketMock) RequestHandlerOption {
+	return func(o *requestHandlerOptions) error {
+		if ws == nil {
+			return fmt.Errorf("websocket mock must not be nil")
+		}
+		if len(o.response) != 0 {
+			return fmt.Errorf("websocket can't be set with response")
+		}
+		o.webSocket = ws
+		o.handler = ws.serve
+		o.matchers = append(o.matchers, webSocketUpgradeMatcher())
+		return nil
+	}
+}
+
+// WithName option sets a name for the handler, the name is used to identify the handler in the requests journal
+var WithName = func(name string) RequestHandlerOption {
+	return func(o *requestHandlerOptions) error {
+		o.name = name
+		return nil
+	}
+}
+
+// WithRequestNumber option sets the request number for the handler.
+// The request number is counted by the server across all the handlers, use WithOrdinal to count only the requests of the handler
 var WithRequestNumber = func(reqNum int) RequestHandlerOption {
 	return func(o *requestHandlerOptions) error {
 		o.reqNum = reqNum
@@ -94,6 +435,122 @@ var WithRequestNumber = func(reqNum int) RequestHandlerOption {
 	}
 }
 
+// WithOrdinal option makes the handler handle only the nth request (counting from 1) that matches its other matchers,
+// e.g. WithPath("/_bulk"), WithOrdinal(2) handles the second bulk request regardless of the requests to other paths
+var WithOrdinal = func(n int) RequestHandlerOption {
+	return func(o *requestHandlerOptions) error {
+		if n < 1 {
+			return fmt.Errorf("ordinal must be positive, got %d", n)
+		}
+		o.ordinal = &ordinalRange{from: n, to: n}
+		return nil
+	}
+}
+
+// WithOrdinalRange option makes the handler handle only the requests from the from-th to the to-th (inclusive, counting from 1)
+// of the requests that match its other matchers, to 0 means there is no upper bound
+var WithOrdinalRange = func(from, to int) RequestHandlerOption {
+	return func(o *requestHandlerOptions) error {
+		if from < 1 || (to != 0 && to < from) {
+			return fmt.Errorf("invalid ordinal range %d-%d", from, to)
+		}
+		o.ordinal = &ordinalRange{from: from, to: to}
+		return nil
+	}
+}
+
+// WithTimes option sets an expectation that the handler will be called exactly times times, checked by TestServer.Verify
+var WithTimes = func(times int) RequestHandlerOption {
+	return func(o *requestHandlerOptions) error {
+		if times < 0 {
+			return fmt.Errorf("times must not be negative")
+		}
+		o.expectation = &callExpectation{min: times, max: times}
+		return nil
+	}
+}
+
+// WithAtLeast option sets an expectation that the handler will be called at least times times, checked by TestServer.Verify
+var WithAtLeast = func(times int) RequestHandlerOption {
+	return func(o *requestHandlerOptions) error {
+		if times < 0 {
+			return fmt.Errorf("times must not be negative")
+		}
+		o.expectation = &callExpectation{min: times, max: unlimitedCalls}
+		return nil
+	}
+}
+
+// WithAtMost option sets an expectation that the handler will be called at most times times, checked by TestServer.Verify
+var WithAtMost = func(times int) RequestHandlerOption {
+	return func(o *requestHandlerOptions) error {
+		if times < 0 {
+			return fmt.Errorf("times must not be negative")
+		}
+		o.expectation = &callExpectation{min: 0, max: times}
+		return nil
+	}
+}
+
+// WithNever option sets an expectation that the handler will never be called, checked by TestServer.Verify
+var WithNever = func() RequestHandlerOption {
+	return WithTimes(0)
+}
+
+// WithScenario option makes the handler part of the scenario name, the handler is active only while the scenario is in requiredState.
+// An empty requiredState makes the handler active in any state. Scenarios start in the ScenarioStarted state
+var WithScenario = func(name string, requiredState string) RequestHandlerOption {
+	return func(o *requestHandlerOptions) error {
+		if name == "" {
+			return fmt.Errorf("scenario name must not be empty")
+		}
+		if o.scenario == nil {
+			o.scenario = &handlerScenario{}
+		}
+		o.scenario.name = name
+		o.scenario.requiredState = requiredState
+		return nil
+	}
+}
+
+// WithNewScenarioState option moves the handler scenario (see WithScenario) to state once the handler is selected for a request
+var WithNewScenarioState = func(state string) RequestHandlerOption {
+	return func(o *requestHandlerOptions) error {
+		if state == "" {
+			return fmt.Errorf("new scenario state must not be empty")
+		}
+		if o.scenario == nil {
+			o.scenario = &handlerScenario{}
+		}
+		o.scenario.newState = state
+		return nil
+	}
+}
+
+// WithPriority option sets the handler priority for first match routing (see WithFirstMatchRouting), the highest priority handler wins
+var WithPriority = func(priority int) RequestHandlerOption {
+	return func(o *requestHandlerOptions) error {
+		o.priority = priority
+		return nil
+	}
+}
+
+// WithFault option injects faults into the handler responses, see the Fault constructors for the supported faults
+var WithFault = func(faults ...Fault) RequestHandlerOption {
+	return func(o *requestHandlerOptions) error {
+		if err := validateFaults(faults); err != nil {
+			return err
+		}
+		o.faults = append(o.faults, faults...)
+		return nil
+	}
+}
+
+// WithDelay option delays the handler responses
+var WithDelay = func(delay time.Duration) RequestHandlerOption {
+	return WithFault(DelayFault(delay))
+}
+
 // Deprecated: Use WithTestRequestV1 - keep only for elastic tests
 var WithTestRequest = func(t *testing.T, updateExpected bool, expectedRequest []byte, expectedRequestFile string) RequestHandlerOption {
 	return func(o *requestHandlerOptions) error {
@@ -132,18 +589,31 @@ var WithTestRequestV1 = func(t *testing.T, updateExpected bool, expectedRequest
 }
 
 type requestHandlerOptions struct {
+	name                   string
 	method                 string
 	path                   string
 	response               []byte
-	responses              [][]byte
+	responses              []Response
+	statusCode             int
+	responseHeaders        map[string]string
 	expectedRequest        []byte
 	requestCompareOptions  []cmp.Option
 	expectedRequestFile    string
 	updateExpected         bool
 	reqNum                 int
+	ordinal                *ordinalRange
 	pathPrefix             string
 	pathSuffix             string
+	pathPattern            *pathPattern
+	matchers               []requestMatcher
 	handler                RequestHandler
+	priority               int
+	scenario               *handlerScenario
+	expectation            *callExpectation
+	faults                 []Fault
+	webSocket              *WebSocketMock
+	stream                 *responseStream
+	template               *responseTemplate
 	t                      *testing.T
 	deprecatedTestResponse bool
 }
@@ -154,29 +624,83 @@ func (o *requestHandlerOptions) validate() error {
 			return fmt.Errorf("test is required for update expected")
 		}
 	}
+	if o.scenario != nil && o.scenario.name == "" {
+		return fmt.Errorf("new scenario state can't be set without a scenario")
+	}
+	if o.handler != nil && (o.statusCode != 0 || len(o.responseHeaders) != 0) {
+		return fmt.Errorf("status code and response headers can't be set with handler")
+	}
+	if o.stream != nil && (o.handler != nil || len(o.response) != 0 || len(o.responses) != 0) {
+		return fmt.Errorf("streamed response can't be set with handler, response or responses array")
+	}
+	if o.template != nil && (o.handler != nil || len(o.response) != 0 || o.stream != nil) {
+		return fmt.Errorf("response template can't be set with handler, response or streamed response")
+	}
 	return nil
 }
 
-func (o *requestHandlerOptions) getOrCreateHandler() RequestHandler {
-	if o.handler != nil {
-		return o.handler
+// describe returns the handler name or a description of its matchers if no name was set
+func (o *requestHandlerOptions) describe() string {
+	if o.name != "" {
+		return o.name
 	}
-	return func(w http.ResponseWriter, r *http.Request, reqBody string) {
-		if o.deprecatedTestResponse && len(o.expectedRequest) != 0 {
-			utils.DeepEqualOrUpdate(o.t, []byte(reqBody), o.expectedRequest, o.expectedRequestFile, o.updateExpected)
-		} else if len(o.expectedRequest) != 0 {
-			utils.CompareAndUpdate(o.t, []byte(reqBody), o.expectedRequest, o.expectedRequestFile, o.updateExpected, o.requestCompareOptions...)
-		}
-		if len(o.response) != 0 {
-			w.Write(o.response)
-		}
-		if len(o.responses) != 0 {
-			//pop the next response
-			var response []byte
-			response, o.responses = o.responses[0], o.responses[1:]
-			w.Write(response)
+	method := o.method
+	if method == "" {
+		method = "*"
+	}
+	path := "*"
+	switch {
+	case o.path != "":
+		path = o.path
+	case o.pathPrefix != "" || o.pathSuffix != "":
+		path = o.pathPrefix + "*" + o.pathSuffix
+	case o.pathPattern != nil:
+		path = o.pathPattern.pattern
+	}
+	description := fmt.Sprintf("%s %s", method, path)
+	if o.reqNum != 0 {
+		description = fmt.Sprintf("%s #%d", description, o.reqNum)
+	}
+	if o.ordinal != nil {
+		description = fmt.Sprintf("%s ordinal %s", description, o.ordinal)
+	}
+	return description
+}
+
+// respond writes the response of a handler without a custom handler, merged with the next response of the sequence if claimed
+func (o *requestHandlerOptions) respond(w http.ResponseWriter, r *http.Request, reqBody string, next *Response) {
+	if o.deprecatedTestResponse && len(o.expectedRequest) != 0 {
+		utils.DeepEqualOrUpdate(o.t, []byte(reqBody), o.expectedRequest, o.expectedRequestFile, o.updateExpected)
+	} else if len(o.expectedRequest) != 0 {
+		utils.CompareAndUpdate(o.t, []byte(reqBody), o.expectedRequest, o.expectedRequestFile, o.updateExpected, o.requestCompareOptions...)
+	}
+	response := Response{StatusCode: o.statusCode, Headers: o.responseHeaders, Body: o.response, template: o.template}
+	if next != nil {
+		response = response.merge(*next)
+	}
+	response, err := response.render(r, reqBody)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if o.stream != nil {
+		if _, ok := response.Headers[contentTypeHeader]; !ok {
+			w.Header().Set(contentTypeHeader, o.stream.contentType)
 		}
+		response.write(w)
+		o.stream.serve(w, r)
+		return
+	}
+	response.write(w)
+}
+
+// validateHandlerOptions checks that the options are valid without creating a handler
+func validateHandlerOptions(opts ...RequestHandlerOption) error {
+	options, err := makeRequestHandlerOptions(opts...)
+	if err != nil {
+		return err
 	}
+	return options.validate()
 }
 
 func makeRequestHandlerOptions(opts ...RequestHandlerOption) (*requestHandlerOptions, error) {
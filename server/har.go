@@ -0,0 +1,262 @@
+package server
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	harVersion     = "1.2"
+	harCreatorName = "ca-test"
+	harHTTPVersion = "HTTP/1.1"
+)
+
+// HAR 1.2 format, see http://www.softwareishard.com/blog/har-12-spec/
+type har struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	//Faults are the faults injected into the response, a custom field
+	Faults []string `json:"_faults,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	//Encoding is base64 for request bodies that are not valid UTF-8, a custom field as HAR has no encoding for post data
+	Encoding string `json:"_encoding,omitempty"`
+}
+
+type harContent struct {
+	Size        int    `json:"size"`
+	Compression int    `json:"compression,omitempty"`
+	MimeType    string `json:"mimeType"`
+	Text        string `json:"text,omitempty"`
+	Encoding    string `json:"encoding,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// decodeContentEncoding decodes a body compressed with the gzip or deflate content encoding
+func decodeContentEncoding(body, contentEncoding string) (string, error) {
+	var reader io.ReadCloser
+	var err error
+	switch strings.ToLower(strings.TrimSpace(contentEncoding)) {
+	case "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		reader, err = gzip.NewReader(strings.NewReader(body))
+	case "deflate":
+		reader, err = zlib.NewReader(strings.NewReader(body))
+	default:
+		return "", fmt.Errorf("unsupported content encoding %s", contentEncoding)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	decoded, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	return string(decoded), nil
+}
+
+// ExportHAR writes the requests journal with the responses written by the server to fileName in HAR 1.2 format
+func (ts *mockTestingServer) ExportHAR(fileName string) error {
+	entries := []harEntry{}
+	for _, request := range ts.GetRequests() {
+		entries = append(entries, newHAREntry(ts.GetURL(), request))
+	}
+	data, err := json.MarshalIndent(&har{Log: harLog{
+		Version: harVersion,
+		Creator: harCreator{Name: harCreatorName, Version: harVersion},
+		Entries: entries,
+	}}, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, data, 0644)
+}
+
+func newHAREntry(baseURL string, request RecordedRequest) harEntry {
+	requestURL := baseURL + request.URL
+	httpVersion := request.Protocol
+	if httpVersion == "" {
+		httpVersion = harHTTPVersion
+	}
+	headers := http.Header(request.Headers)
+	harReq := harRequest{
+		Method:      request.Method,
+		URL:         requestURL,
+		HTTPVersion: httpVersion,
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(headers),
+		QueryString: []harNameValue{},
+		HeadersSize: -1,
+		BodySize:    len(request.Body),
+	}
+	if u, err := url.Parse(requestURL); err == nil {
+		harReq.QueryString = harValues(u.Query())
+	}
+	if request.Body != "" {
+		text, encoding := encodeBody(request.Body)
+		harReq.PostData = &harPostData{MimeType: headers.Get(contentTypeHeader), Text: text, Encoding: encoding}
+	}
+	//requests without a response, e.g. aborted by a fault, are exported with status 0 as browsers do for failed requests
+	harResp := harResponse{
+		HTTPVersion: httpVersion,
+		Cookies:     []harNameValue{},
+		Headers:     []harNameValue{},
+		HeadersSize: -1,
+		BodySize:    -1,
+	}
+	if response := request.Response; response != nil {
+		responseHeaders := http.Header(response.Headers)
+		harResp.Status = response.StatusCode
+		harResp.StatusText = http.StatusText(response.StatusCode)
+		body := response.Body
+		if contentEncoding := responseHeaders.Get(contentEncodingHeader); contentEncoding != "" {
+			//the HAR content is the decoded body, the header is dropped if the body can't be decoded so it is not applied to it
+			if decoded, err := decodeContentEncoding(body, contentEncoding); err == nil {
+				body = decoded
+			} else {
+				responseHeaders = responseHeaders.Clone()
+				responseHeaders.Del(contentEncodingHeader)
+			}
+		}
+		harResp.Headers = harHeaders(responseHeaders)
+		text, encoding := encodeBody(body)
+		harResp.Content = harContent{Size: len(body), Compression: len(body) - len(response.Body), MimeType: responseHeaders.Get(contentTypeHeader), Text: text, Encoding: encoding}
+		harResp.BodySize = len(response.Body)
+	}
+	duration := float64(request.Duration) / float64(time.Millisecond)
+	return harEntry{
+		StartedDateTime: request.Timestamp.Format(time.RFC3339Nano),
+		Time:            duration,
+		Request:         harReq,
+		Response:        harResp,
+		Timings:         harTimings{Send: 0, Wait: duration, Receive: 0},
+		Faults:          request.Faults,
+	}
+}
+
+func harHeaders(headers http.Header) []harNameValue {
+	return harValues(url.Values(headers))
+}
+
+// harValues converts multi valued maps to a list of name value pairs sorted by name
+func harValues(values url.Values) []harNameValue {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := []harNameValue{}
+	for _, name := range names {
+		for _, value := range values[name] {
+			pairs = append(pairs, harNameValue{Name: name, Value: value})
+		}
+	}
+	return pairs
+}
+
+// loadHARExchanges reads the entries of a HAR file as recorded requests and responses
+func loadHARExchanges(fileName string) ([]replayExchange, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HAR file %s: %v", fileName, err)
+	}
+	archive := har{}
+	if err := json.Unmarshal(data, &archive); err != nil {
+		return nil, fmt.Errorf("failed to parse HAR file %s: %v", fileName, err)
+	}
+	exchanges := []replayExchange{}
+	for i, entry := range archive.Log.Entries {
+		u, err := url.Parse(entry.Request.URL)
+		if err != nil {
+			return nil, fmt.Errorf("HAR file %s entry %d has an invalid url: %v", fileName, i, err)
+		}
+		exchange := replayExchange{method: entry.Request.Method, url: u.RequestURI()}
+		if postData := entry.Request.PostData; postData != nil {
+			if exchange.body, err = decodeBody(postData.Text, postData.Encoding); err != nil {
+				return nil, fmt.Errorf("HAR file %s entry %d has an invalid post data: %v", fileName, i, err)
+			}
+		}
+		if entry.Response.Status != 0 {
+			body, err := decodeBody(entry.Response.Content.Text, entry.Response.Content.Encoding)
+			if err != nil {
+				return nil, fmt.Errorf("HAR file %s entry %d has an invalid content: %v", fileName, i, err)
+			}
+			headers := http.Header{}
+			for _, header := range entry.Response.Headers {
+				headers.Add(header.Name, header.Value)
+			}
+			//the HAR content is the decoded body, browsers keep the Content-Encoding header of the compressed body they received
+			headers.Del(contentEncodingHeader)
+			exchange.response = &RecordedResponse{StatusCode: entry.Response.Status, Headers: headers, Body: body}
+		}
+		exchanges = append(exchanges, exchange)
+	}
+	return exchanges, nil
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+// binaryBody is not valid UTF-8, it is corrupted if written as JSON text
+var binaryBody = []byte{0x08, 0x96, 0x01, 0xff, 0xfe, 0x00, 0x80}
+
+func TestHARExportKeepsBinaryBodies(t *testing.T) {
+	ts := NewTestServerWithCleanup(t)
+	if _, err := ts.AddHandler(WithPath("/blob"), WithResponse(binaryBody)); err != nil {
+		t.Fatal(err)
+	}
+	doRequest(t, http.MethodGet, ts.GetURL()+"/blob")
+	fileName := filepath.Join(t.TempDir(), "journal.har")
+	if err := ts.ExportHAR(fileName); err != nil {
+		t.Fatal(err)
+	}
+
+	replay := NewTestServerWithCleanup(t, WithHARStubs(fileName, ReplayMatchMethodPath))
+	if body := doRequest(t, http.MethodGet, replay.GetURL()+"/blob").body; !bytes.Equal(body, binaryBody) {
+		t.Errorf("expected body %v got %v", binaryBody, body)
+	}
+}
+
+func TestRecordsKeepBinaryBodies(t *testing.T) {
+	folder := t.TempDir()
+	ts := NewTestServerWithCleanup(t, WithRequestsRecorder(true, folder, 0, false))
+	if _, err := ts.AddHandler(WithPath("/blob"), WithResponse(binaryBody)); err != nil {
+		t.Fatal(err)
+	}
+	doRequest(t, http.MethodGet, ts.GetURL()+"/blob")
+	ts.Close()
+
+	replay := NewTestServerWithCleanup(t, WithReplay(folder, ReplayMatchMethodPath))
+	if body := doRequest(t, http.MethodGet, replay.GetURL()+"/blob").body; !bytes.Equal(body, binaryBody) {
+		t.Errorf("expected body %v got %v", binaryBody, body)
+	}
+}
+
+// browserHAR is shaped like the HAR files exported by browsers, the content text is decoded while the Content-Encoding header is kept
+const browserHAR = `{
+  "log": {
+    "version": "1.2",
+    "creator": {"name": "WebInspector", "version": "537.36"},
+    "pages": [],
+    "entries": [
+      {
+        "startedDateTime": "2023-10-01T10:00:00.000Z",
+        "time": 12.5,
+        "request": {
+          "method": "GET",
+          "url": "http://localhost:9200/_cluster/health?pretty",
+          "httpVersion": "HTTP/1.1",
+          "headers": [{"name": "Accept-Encoding", "value": "gzip, deflate, br"}],
+          "queryString": [{"name": "pretty", "value": ""}],
+          "cookies": [],
+          "headersSize": -1,
+          "bodySize": 0
+        },
+        "response": {
+          "status": 200,
+          "statusText": "OK",
+          "httpVersion": "HTTP/1.1",
+          "headers": [
+            {"name": "content-type", "value": "application/json; charset=UTF-8"},
+            {"name": "content-encoding", "value": "gzip"},
+            {"name": "content-length", "value": "120"}
+          ],
+          "cookies": [],
+          "content": {"size": 34, "mimeType": "application/json", "compression": 86, "text": "{\"status\":\"green\",\"cluster\":\"ca\"}"},
+          "redirectURL": "",
+          "headersSize": -1,
+          "bodySize": 120,
+          "_transferSize": 300
+        },
+        "cache": {},
+        "timings": {"blocked": 1, "dns": -1, "ssl": -1, "connect": -1, "send": 0.1, "wait": 10, "receive": 1.4}
+      }
+    ]
+  }
+}`
+
+func TestBrowserHARStubs(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "browser.har")
+	if err := ioutil.WriteFile(fileName, []byte(browserHAR), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ts := NewTestServerWithCleanup(t, WithHARStubs(fileName, ReplayMatchMethodPath))
+	resp, err := http.Get(ts.GetURL() + "/_cluster/health?pretty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read the body: %v", err)
+	}
+	if string(body) != `{"status":"green","cluster":"ca"}` {
+		t.Errorf("unexpected body %s", body)
+	}
+	if contentType := resp.Header.Get(contentTypeHeader); contentType != "application/json; charset=UTF-8" {
+		t.Errorf("unexpected content type %s", contentType)
+	}
+}
+
+func TestHARExportDecodesCompressedBodies(t *testing.T) {
+	const plainBody = `{"status":"green"}`
+	ts := NewTestServerWithCleanup(t)
+	if _, err := ts.AddHandler(WithPath("/gzip"), WithHandler(func(w http.ResponseWriter, r *http.Request, reqBody string) {
+		w.Header().Set(contentEncodingHeader, "gzip")
+		writer := gzip.NewWriter(w)
+		writer.Write([]byte(plainBody))
+		writer.Close()
+	})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.AddHandler(WithPath("/br"), WithResponseHeaders(map[string]string{contentEncodingHeader: "br"}), WithResponse(binaryBody)); err != nil {
+		t.Fatal(err)
+	}
+	if body := string(doRequest(t, http.MethodGet, ts.GetURL()+"/gzip").body); body != plainBody {
+		t.Fatalf("unexpected body %s", body)
+	}
+	doRequest(t, http.MethodGet, ts.GetURL()+"/br")
+	fileName := filepath.Join(t.TempDir(), "journal.har")
+	if err := ts.ExportHAR(fileName); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported := har{}
+	if err := json.Unmarshal(data, &exported); err != nil {
+		t.Fatal(err)
+	}
+	if len(exported.Log.Entries) != 2 {
+		t.Fatalf("expected 2 entries got %d", len(exported.Log.Entries))
+	}
+	gzipResponse := exported.Log.Entries[0].Response
+	if gzipResponse.Content.Text != plainBody || gzipResponse.Content.Size != len(plainBody) {
+		t.Errorf("expected the decoded body got %+v", gzipResponse.Content)
+	}
+	if headers := harHeaderValues(gzipResponse.Headers, contentEncodingHeader); len(headers) != 1 || headers[0] != "gzip" {
+		t.Errorf("expected the gzip content encoding to be kept got %v", headers)
+	}
+	//the br body can't be decoded, it is exported as is without the header
+	if headers := harHeaderValues(exported.Log.Entries[1].Response.Headers, contentEncodingHeader); len(headers) != 0 {
+		t.Errorf("expected the br content encoding to be dropped got %v", headers)
+	}
+
+	replay := NewTestServerWithCleanup(t, WithHARStubs(fileName, ReplayMatchMethodPath))
+	if body := string(doRequest(t, http.MethodGet, replay.GetURL()+"/gzip").body); body != plainBody {
+		t.Errorf("expected body %s got %s", plainBody, body)
+	}
+	if body := doRequest(t, http.MethodGet, replay.GetURL()+"/br").body; !bytes.Equal(body, binaryBody) {
+		t.Errorf("expected body %v got %v", binaryBody, body)
+	}
+}
+
+func harHeaderValues(headers []harNameValue, name string) []string {
+	values := []string{}
+	for _, header := range headers {
+		if http.CanonicalHeaderKey(header.Name) == name {
+			values = append(values, header.Value)
+		}
+	}
+	return values
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// httpHandler returns the server handler, wrapped to serve cleartext HTTP/2 if h2c is enabled
+func (ts *mockTestingServer) httpHandler() http.Handler {
+	handler := http.Handler(http.HandlerFunc(ts.mainHandler))
+	if ts.options.h2c {
+		handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+	return handler
+}
+
+// newH2CTransport returns a transport speaking cleartext HTTP/2 with prior knowledge
+func newH2CTransport() http.RoundTripper {
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// RecordedRequest is a request captured by the server journal
+type RecordedRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	//Protocol is the negotiated protocol of the request, e.g. HTTP/1.1 or HTTP/2.0
+	Protocol      string              `json:"protocol,omitempty"`
+	Headers       map[string][]string `json:"headers,omitempty"`
+	Body          string              `json:"body,omitempty"`
+	RequestNumber int                 `json:"req_num"`
+	Handlers      []string            `json:"handlers,omitempty"`
+	Faults        []string            `json:"faults,omitempty"`
+	Proxied       bool                `json:"proxied,omitempty"`
+	//ClientIdentity is the common name of the client certificate if mutual TLS is enabled
+	ClientIdentity string            `json:"client_identity,omitempty"`
+	Response       *RecordedResponse `json:"response,omitempty"`
+	Timestamp      time.Time         `json:"timestamp"`
+	//Duration is the time it took the server to respond
+	Duration time.Duration `json:"duration"`
+}
+
+func newRecordedRequest(r *http.Request, reqBody string, reqNum int, timestamp time.Time) RecordedRequest {
+	return RecordedRequest{
+		Method:         r.Method,
+		URL:            r.URL.String(),
+		Protocol:       r.Proto,
+		Headers:        r.Header.Clone(),
+		Body:           reqBody,
+		RequestNumber:  reqNum,
+		ClientIdentity: clientIdentity(r),
+		Timestamp:      timestamp,
+	}
+}
+
+// toHTTPRequest rebuilds an http request from the recorded data so it can be evaluated by the handlers matchers
+func (rr *RecordedRequest) toHTTPRequest() *http.Request {
+	u, err := url.Parse(rr.URL)
+	if err != nil {
+		u = &url.URL{Path: rr.URL}
+	}
+	return &http.Request{
+		Method: rr.Method,
+		URL:    u,
+		Header: http.Header(rr.Headers).Clone(),
+		Body:   ioutil.NopCloser(bytes.NewBufferString(rr.Body)),
+	}
+}
+
+func copyRecordedRequests(requests []RecordedRequest) []RecordedRequest {
+	result := make([]RecordedRequest, len(requests))
+	copy(result, requests)
+	return result
+}
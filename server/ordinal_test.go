@@ -0,0 +1,130 @@
+package server
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestOrdinals(t *testing.T) {
+	ts := NewTestServerWithCleanup(t)
+	if _, err := ts.AddHandler(WithPath("/_bulk"), WithOrdinal(2), WithStatusCode(http.StatusTooManyRequests)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.AddHandler(WithPath("/_search"), WithOrdinalRange(2, 3), WithStatusCode(http.StatusServiceUnavailable)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.AddHandler(WithPath("/_count"), WithOrdinalRange(2, 0), WithStatusCode(http.StatusAccepted)); err != nil {
+		t.Fatal(err)
+	}
+	requests := []struct {
+		path   string
+		status int
+	}{
+		{"/", http.StatusOK},
+		{"/_bulk", http.StatusOK},
+		{"/", http.StatusOK},
+		{"/_bulk", http.StatusTooManyRequests},
+		{"/_bulk", http.StatusOK},
+		{"/_search", http.StatusOK},
+		{"/_search", http.StatusServiceUnavailable},
+		{"/", http.StatusOK},
+		{"/_search", http.StatusServiceUnavailable},
+		{"/_search", http.StatusOK},
+		{"/_count", http.StatusOK},
+		{"/_count", http.StatusAccepted},
+		{"/_count", http.StatusAccepted},
+	}
+	for i, request := range requests {
+		if status := doRequest(t, http.MethodGet, ts.GetURL()+request.path).status; status != request.status {
+			t.Errorf("request %d %s: expected status %d got %d", i+1, request.path, request.status, status)
+		}
+	}
+
+	found, err := ts.FindRequests(WithPath("/_search"), WithOrdinalRange(3, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 || found[0].RequestNumber != 9 || found[1].RequestNumber != 10 {
+		t.Errorf("unexpected requests found %v", found)
+	}
+}
+
+func TestOrdinalMismatch(t *testing.T) {
+	ts := NewTestServerWithCleanup(t, WithUnmatchedResponse(http.StatusNotFound))
+	if _, err := ts.AddHandler(WithPath("/a"), WithOrdinal(2)); err != nil {
+		t.Fatal(err)
+	}
+	resp := doRequest(t, http.MethodGet, ts.GetURL()+"/a")
+	if resp.status != http.StatusNotFound {
+		t.Errorf("expected status %d got %d", http.StatusNotFound, resp.status)
+	}
+	if expected := "ordinal: expected 2 got 1"; !strings.Contains(string(resp.body), expected) {
+		t.Errorf("expected the nearest misses to contain %q got %s", expected, resp.body)
+	}
+}
+
+func TestOrdinalRestartsOnReplace(t *testing.T) {
+	ts := NewTestServerWithCleanup(t)
+	handler, err := ts.AddHandler(WithPath("/a"), WithOrdinal(1), WithStatusCode(http.StatusCreated))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doRequest(t, http.MethodGet, ts.GetURL()+"/a")
+	if err := handler.Replace(WithPath("/a"), WithOrdinal(1), WithStatusCode(http.StatusAccepted)); err != nil {
+		t.Fatal(err)
+	}
+	if status := doRequest(t, http.MethodGet, ts.GetURL()+"/a").status; status != http.StatusAccepted {
+		t.Errorf("expected status %d got %d", http.StatusAccepted, status)
+	}
+}
+
+func TestMatchersEvaluatedOncePerRequest(t *testing.T) {
+	var calls int64
+	predicate := func(reqBody string) bool {
+		atomic.AddInt64(&calls, 1)
+		return true
+	}
+	ts := NewTestServerWithCleanup(t)
+	if _, err := ts.AddHandler(WithPath("/a"), WithBodyPredicate(predicate), WithOrdinalRange(1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		doRequest(t, http.MethodGet, ts.GetURL()+"/a")
+	}
+	if calls := atomic.LoadInt64(&calls); calls != 5 {
+		t.Errorf("expected the predicate to be called 5 times got %d", calls)
+	}
+}
+
+func TestBuiltInRequestsUncounted(t *testing.T) {
+	ts := NewTestServerWithCleanup(t, WithBuiltInRequestsUncounted(), WithBuiltInHandler(WithMethod(http.MethodGet), WithPath("/")))
+	if _, err := ts.AddHandler(WithPath("/x"), WithRequestNumber(2), WithStatusCode(http.StatusCreated)); err != nil {
+		t.Fatal(err)
+	}
+	for i, request := range []struct {
+		path   string
+		status int
+	}{
+		{"/", http.StatusOK},
+		{"/x", http.StatusOK},
+		{"/", http.StatusOK},
+		{"/x", http.StatusCreated},
+	} {
+		if status := doRequest(t, http.MethodGet, ts.GetURL()+request.path).status; status != request.status {
+			t.Errorf("request %d %s: expected status %d got %d", i+1, request.path, request.status, status)
+		}
+	}
+	if count := ts.GetRequestCount(); count != 2 {
+		t.Errorf("expected 2 counted requests got %d", count)
+	}
+	requestNumbers := []int{}
+	for _, request := range ts.GetRequests() {
+		requestNumbers = append(requestNumbers, request.RequestNumber)
+	}
+	if expected := []int{0, 1, 0, 2}; !reflect.DeepEqual(requestNumbers, expected) {
+		t.Errorf("expected request numbers %v got %v", expected, requestNumbers)
+	}
+}
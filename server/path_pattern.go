@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+type pathPatternKind string
+
+const (
+	pathTemplate pathPatternKind = "path template"
+	pathGlob     pathPatternKind = "path glob"
+	pathRegex    pathPatternKind = "path regex"
+)
+
+// templateParamRegex matches a {name} parameter of a path template
+var templateParamRegex = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// pathPattern matches a request path against a template, a glob or a regular expression and captures the named parameters
+type pathPattern struct {
+	kind    pathPatternKind
+	pattern string
+	// regex of templates and regular expressions, nil for globs
+	regex *regexp.Regexp
+}
+
+// newPathTemplate compiles a template where each {name} matches a single non empty path segment, e.g. /{index}/_doc/{id}
+func newPathTemplate(template string) (*pathPattern, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+	last := 0
+	names := map[string]bool{}
+	for _, loc := range templateParamRegex.FindAllStringSubmatchIndex(template, -1) {
+		name := template[loc[2]:loc[3]]
+		if names[name] {
+			return nil, fmt.Errorf("path template %s has duplicate parameter %s", template, name)
+		}
+		names[name] = true
+		if err := writeTemplateLiteral(&expr, template, template[last:loc[0]]); err != nil {
+			return nil, err
+		}
+		expr.WriteString(fmt.Sprintf("(?P<%s>[^/]+)", name))
+		last = loc[1]
+	}
+	if err := writeTemplateLiteral(&expr, template, template[last:]); err != nil {
+		return nil, err
+	}
+	expr.WriteString("$")
+	regex, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid path template %s: %v", template, err)
+	}
+	return &pathPattern{kind: pathTemplate, pattern: template, regex: regex}, nil
+}
+
+func writeTemplateLiteral(expr *strings.Builder, template, literal string) error {
+	if strings.ContainsAny(literal, "{}") {
+		return fmt.Errorf("path template %s has an invalid parameter", template)
+	}
+	expr.WriteString(regexp.QuoteMeta(literal))
+	return nil
+}
+
+// newPathGlob creates a glob pattern with the syntax of path.Match, e.g. /*/_settings
+func newPathGlob(glob string) (*pathPattern, error) {
+	if _, err := path.Match(glob, ""); err != nil {
+		return nil, fmt.Errorf("invalid path glob %s: %v", glob, err)
+	}
+	return &pathPattern{kind: pathGlob, pattern: glob}, nil
+}
+
+// newPathRegex compiles a regular expression that must match the entire path, named groups are captured as parameters
+func newPathRegex(expr string) (*pathPattern, error) {
+	regex, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid path regex %s: %v", expr, err)
+	}
+	return &pathPattern{kind: pathRegex, pattern: expr, regex: regex}, nil
+}
+
+func (p *pathPattern) match(urlPath string) bool {
+	if p.regex == nil {
+		matched, _ := path.Match(p.pattern, urlPath)
+		return matched
+	}
+	return p.regex.MatchString(urlPath)
+}
+
+// params returns the named parameters captured from the path, nil if there are none
+func (p *pathPattern) params(urlPath string) map[string]string {
+	if p.regex == nil {
+		return nil
+	}
+	match := p.regex.FindStringSubmatch(urlPath)
+	if match == nil {
+		return nil
+	}
+	params := map[string]string{}
+	for i, name := range p.regex.SubexpNames() {
+		if name != "" {
+			params[name] = match[i]
+		}
+	}
+	return params
+}
+
+type pathParamsKey struct{}
+
+func withPathParams(r *http.Request, params map[string]string) *http.Request {
+	if params == nil {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), pathParamsKey{}, params))
+}
+
+// PathParams returns the parameters captured by the path template or regex of the handler serving the request
+func PathParams(r *http.Request) map[string]string {
+	params, _ := r.Context().Value(pathParamsKey{}).(map[string]string)
+	result := map[string]string{}
+	for k, v := range params {
+		result[k] = v
+	}
+	return result
+}
+
+// PathParam returns a parameter captured by the path template or regex of the handler serving the request, empty if not captured
+func PathParam(r *http.Request, name string) string {
+	params, _ := r.Context().Value(pathParamsKey{}).(map[string]string)
+	return params[name]
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+)
+
+// upstreamProxy forwards requests to an upstream server, see WithProxy
+type upstreamProxy struct {
+	upstream *url.URL
+	//all forwards all the requests instead of only the requests no handler matched
+	all          bool
+	reverseProxy *httputil.ReverseProxy
+}
+
+func newUpstreamProxy(upstreamURL string, all bool) (*upstreamProxy, error) {
+	upstream, err := url.Parse(upstreamURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream url %s: %v", upstreamURL, err)
+	}
+	if upstream.Scheme == "" || upstream.Host == "" {
+		return nil, fmt.Errorf("upstream url %s must have a scheme and a host", upstreamURL)
+	}
+	reverseProxy := httputil.NewSingleHostReverseProxy(upstream)
+	director := reverseProxy.Director
+	reverseProxy.Director = func(r *http.Request) {
+		director(r)
+		r.Host = upstream.Host
+		//let the transport negotiate compression so the captured responses are not encoded
+		r.Header.Del("Accept-Encoding")
+	}
+	return &upstreamProxy{upstream: upstream, all: all, reverseProxy: reverseProxy}, nil
+}
+
+// shouldProxy returns true if the request should be forwarded given the number of handlers that matched it
+func (p *upstreamProxy) shouldProxy(handlersCount int) bool {
+	return p != nil && (p.all || handlersCount == 0)
+}
+
+func (p *upstreamProxy) serve(w http.ResponseWriter, r *http.Request) {
+	p.reverseProxy.ServeHTTP(w, r)
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"fmt"
+)
+
+// RegisteredHandler is a handle to a handler of a TestServer, returned by AddHandler and GetHandlers
+type RegisteredHandler interface {
+	//get the handler id, the id is used by the admin API and is kept by Replace
+	ID() int64
+	//get the handler name or a description of its matchers
+	Name() string
+	//true for handlers added with WithBuiltInHandler
+	IsBuiltIn() bool
+	//get the number of requests the handler served since it was added or replaced
+	HitCount() int
+	//get the requests the handler served since it was added or replaced, in the order they were received
+	Requests() []RecordedRequest
+	//replace the options of the handler keeping its id and its place among the handlers, the hit count and requests start over
+	Replace(opts ...RequestHandlerOption) error
+	//remove the handler from the server, error is returned if it was already removed
+	Remove() error
+}
+
+type registeredHandler struct {
+	ts      *mockTestingServer
+	id      int64
+	builtIn bool
+}
+
+func (rh *registeredHandler) ID() int64 {
+	return rh.id
+}
+
+func (rh *registeredHandler) IsBuiltIn() bool {
+	return rh.builtIn
+}
+
+func (rh *registeredHandler) Name() string {
+	rh.ts.handlersMux.RLock()
+	defer rh.ts.handlersMux.RUnlock()
+	if handler := rh.ts.findHandler(rh.id); handler != nil {
+		return handler.options.describe()
+	}
+	return ""
+}
+
+func (rh *registeredHandler) HitCount() int {
+	rh.ts.mux.Lock()
+	defer rh.ts.mux.Unlock()
+	rh.ts.handlersMux.RLock()
+	defer rh.ts.handlersMux.RUnlock()
+	if handler := rh.ts.findHandler(rh.id); handler != nil {
+		return handler.hits
+	}
+	return 0
+}
+
+func (rh *registeredHandler) Requests() []RecordedRequest {
+	rh.ts.mux.Lock()
+	defer rh.ts.mux.Unlock()
+	rh.ts.handlersMux.RLock()
+	defer rh.ts.handlersMux.RUnlock()
+	requests := []RecordedRequest{}
+	handler := rh.ts.findHandler(rh.id)
+	if handler == nil {
+		return requests
+	}
+	for i, served := range rh.ts.journalHandlers {
+		for _, h := range served {
+			if h == handler {
+				requests = append(requests, rh.ts.journal[i])
+				break
+			}
+		}
+	}
+	return requests
+}
+
+func (rh *registeredHandler) Replace(opts ...RequestHandlerOption) error {
+	replacement, err := newRequestHandler(opts...)
+	if err != nil {
+		return err
+	}
+	replacement.id = rh.id
+	rh.ts.mux.Lock()
+	defer rh.ts.mux.Unlock()
+	rh.ts.handlersMux.Lock()
+	defer rh.ts.handlersMux.Unlock()
+	var found bool
+	if rh.builtIn {
+		rh.ts.options.defaultRequestHandlers, found = replaceHandlerByID(rh.ts.options.defaultRequestHandlers, replacement)
+	} else {
+		rh.ts.requestHandlers, found = replaceHandlerByID(rh.ts.requestHandlers, replacement)
+	}
+	if !found {
+		return fmt.Errorf("handler %d was removed", rh.id)
+	}
+	return nil
+}
+
+func (rh *registeredHandler) Remove() error {
+	if !rh.ts.removeHandler(rh.id) {
+		return fmt.Errorf("handler %d was removed", rh.id)
+	}
+	return nil
+}
+
+func (ts *mockTestingServer) GetHandlers() []RegisteredHandler {
+	ts.handlersMux.RLock()
+	defer ts.handlersMux.RUnlock()
+	handlers := []RegisteredHandler{}
+	for _, handler := range ts.options.defaultRequestHandlers {
+		handlers = append(handlers, &registeredHandler{ts: ts, id: handler.id, builtIn: true})
+	}
+	for _, handler := range ts.requestHandlers {
+		handlers = append(handlers, &registeredHandler{ts: ts, id: handler.id})
+	}
+	return handlers
+}
+
+// findHandler returns the built in or added handler with the id, nil if it was removed, the caller holds the handlers lock
+func (ts *mockTestingServer) findHandler(id int64) *serverRequestHandler {
+	for _, handlers := range [][]*serverRequestHandler{ts.options.defaultRequestHandlers, ts.requestHandlers} {
+		for _, handler := range handlers {
+			if handler.id == id {
+				return handler
+			}
+		}
+	}
+	return nil
+}
+
+// replaceHandlerByID returns a copy of the handlers with the handler of the same id replaced, requests being served keep the handlers they were dispatched with
+func replaceHandlerByID(handlers []*serverRequestHandler, replacement *serverRequestHandler) ([]*serverRequestHandler, bool) {
+	for i, handler := range handlers {
+		if handler.id == replacement.id {
+			replaced := append([]*serverRequestHandler{}, handlers...)
+			replaced[i] = replacement
+			return replaced, true
+		}
+	}
+	return handlers, false
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestRegisteredHandlerOverride(t *testing.T) {
+	ts := NewTestServerWithCleanup(t, WithFirstMatchRouting(), WithBuiltInHandler(WithName("health"), WithPath("/_cluster/health")))
+	override, err := ts.AddHandler(WithPath("/_cluster/health"), WithStatusCode(http.StatusServiceUnavailable), WithPriority(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := doRequest(t, http.MethodGet, ts.GetURL()+"/_cluster/health").status; status != http.StatusServiceUnavailable {
+		t.Errorf("expected the override status got %d", status)
+	}
+	if hits := override.HitCount(); hits != 1 {
+		t.Errorf("expected 1 hit got %d", hits)
+	}
+	if requests := override.Requests(); len(requests) != 1 || requests[0].URL != "/_cluster/health" {
+		t.Errorf("unexpected requests %v", requests)
+	}
+
+	if err := override.Replace(WithPath("/_cluster/health"), WithStatusCode(http.StatusTooManyRequests), WithPriority(10)); err != nil {
+		t.Fatal(err)
+	}
+	if status := doRequest(t, http.MethodGet, ts.GetURL()+"/_cluster/health").status; status != http.StatusTooManyRequests {
+		t.Errorf("expected the replaced status got %d", status)
+	}
+	if hits := override.HitCount(); hits != 1 {
+		t.Errorf("expected the hit count to start over got %d", hits)
+	}
+
+	if err := override.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if err := override.Remove(); err == nil {
+		t.Error("expected an error removing a removed handler")
+	}
+	if status := doRequest(t, http.MethodGet, ts.GetURL()+"/_cluster/health").status; status != http.StatusOK {
+		t.Errorf("expected the built-in handler status got %d", status)
+	}
+	if handlers := ts.GetHandlers(); len(handlers) != 1 || !handlers[0].IsBuiltIn() || handlers[0].Name() != "health" {
+		t.Errorf("unexpected handlers %v", handlers)
+	}
+}
+
+func TestRegisteredHandlersWhileSettingOptions(t *testing.T) {
+	ts := NewTestServerWithCleanup(t)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			if err := ts.SetOption(WithBuiltInHandler(WithName(fmt.Sprintf("built-in %d", i)))); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			for _, handler := range ts.GetHandlers() {
+				handler.Name()
+				handler.HitCount()
+			}
+		}
+	}()
+	wg.Wait()
+	if handlers := ts.GetHandlers(); len(handlers) != 50 {
+		t.Errorf("expected 50 handlers got %d", len(handlers))
+	}
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ReplayMatching is the strictness used to match requests to recorded requests in replay mode
+type ReplayMatching int
+
+const (
+	// ReplayMatchMethodPath matches requests by method and path
+	ReplayMatchMethodPath ReplayMatching = iota
+	// ReplayMatchQuery matches requests by method, path and query
+	ReplayMatchQuery
+	// ReplayMatchBody matches requests by method, path, query and body
+	ReplayMatchBody
+)
+
+// requestRecord is the content of the request_N.json files written by the requests recorder
+type requestRecord struct {
+	Body          string              `json:"body,omitempty"`
+	BodyObj       interface{}         `json:"bodyObj,omitempty"`
+	RequestNumber int                 `json:"req_num,omitempty"`
+	Headers       map[string][]string `json:"headers,omitempty"`
+	URL           string              `json:"url,omitempty"`
+	Method        string              `json:"method,omitempty"`
+	HandlersCount int                 `json:"handlers_count"`
+	Response      *RecordedResponse   `json:"response,omitempty"`
+}
+
+// replayHeadersToSkip are response headers set by the server itself and not replayed
+var replayHeadersToSkip = map[string]bool{
+	"Content-Length":    true,
+	"Date":              true,
+	"Transfer-Encoding": true,
+	"Connection":        true,
+}
+
+// replayExchange is a recorded request and the response to serve for it
+type replayExchange struct {
+	method   string
+	url      string
+	body     string
+	response *RecordedResponse
+}
+
+// loadRecords reads the request_N.json files of the recordings folder ordered by request number
+func loadRecords(recordsFolder string) ([]requestRecord, error) {
+	files, err := filepath.Glob(filepath.Join(recordsFolder, "request_*.json"))
+	if err != nil {
+		return nil, err
+	}
+	records := []requestRecord{}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read record %s: %v", file, err)
+		}
+		record := requestRecord{}
+		if err := json.Unmarshal(data, &record); err != nil {
+			return nil, fmt.Errorf("failed to parse record %s: %v", file, err)
+		}
+		records = append(records, record)
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].RequestNumber < records[j].RequestNumber
+	})
+	return records, nil
+}
+
+// replayHandlersOptions groups the exchanges by the matching strictness and returns the options of a handler for each group,
+// a group with several exchanges serves the recorded responses in order
+func replayHandlersOptions(exchanges []replayExchange, matching ReplayMatching) ([][]RequestHandlerOption, error) {
+	keys := []string{}
+	groups := map[string][]replayExchange{}
+	for _, exchange := range exchanges {
+		u, err := url.Parse(exchange.url)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recorded url %s: %v", exchange.url, err)
+		}
+		key := exchange.method + " " + u.Path
+		if matching >= ReplayMatchQuery {
+			key += "?" + u.Query().Encode()
+		}
+		if matching >= ReplayMatchBody {
+			key += "\n" + exchange.body
+		}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], exchange)
+	}
+
+	handlersOptions := [][]RequestHandlerOption{}
+	for _, key := range keys {
+		group := groups[key]
+		first := group[0]
+		u, _ := url.Parse(first.url)
+		opts := []RequestHandlerOption{
+			WithName(fmt.Sprintf("replay %s %s", first.method, first.url)),
+			WithMethod(first.method),
+			WithPath(u.Path),
+		}
+		if matching >= ReplayMatchQuery {
+			opts = append(opts, withExactQuery(u.Query()))
+		}
+		if matching >= ReplayMatchBody {
+			opts = append(opts, withEquivalentBody(first.body))
+		}
+		responses := []Response{}
+		for _, exchange := range group {
+			responses = append(responses, replayResponse(exchange.response))
+		}
+		opts = append(opts, WithStatusResponses(responses))
+		handlersOptions = append(handlersOptions, opts)
+	}
+	return handlersOptions, nil
+}
+
+func replayResponse(recorded *RecordedResponse) Response {
+	if recorded == nil {
+		return Response{StatusCode: http.StatusOK}
+	}
+	headers := map[string]string{}
+	for k, v := range recorded.Headers {
+		if !replayHeadersToSkip[http.CanonicalHeaderKey(k)] {
+			headers[k] = strings.Join(v, ", ")
+		}
+	}
+	return Response{StatusCode: recorded.StatusCode, Headers: headers, Body: []byte(recorded.Body)}
+}
+
+// withExactQuery matches requests with exactly the given query parameters
+func withExactQuery(query url.Values) RequestHandlerOption {
+	return func(o *requestHandlerOptions) error {
+		o.matchers = append(o.matchers, requestMatcher{
+			description: fmt.Sprintf("query: expected %s", query.Encode()),
+			match: func(r *http.Request, reqBody string) bool {
+				return r.URL.Query().Encode() == query.Encode()
+			},
+		})
+		return nil
+	}
+}
+
+// withEquivalentBody matches requests with the given body, JSON bodies are compared by value
+func withEquivalentBody(body string) RequestHandlerOption {
+	var expectedJSON interface{}
+	isJSON := json.Unmarshal([]byte(body), &expectedJSON) == nil
+	return WithBodyPredicate(func(reqBody string) bool {
+		if isJSON {
+			var actualJSON interface{}
+			if err := json.Unmarshal([]byte(reqBody), &actualJSON); err == nil {
+				return reflect.DeepEqual(expectedJSON, actualJSON)
+			}
+		}
+		return reqBody == body
+	})
+}
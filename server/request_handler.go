@@ -1,13 +1,43 @@
 package server
 
 import (
+	"fmt"
 	"net/http"
 	"strings"
+	"sync/atomic"
 )
 
+// lastHandlerID is the id of the last handler created, ids identify handlers in the admin API
+var lastHandlerID int64
+
 type serverRequestHandler struct {
+	id      int64
 	options *requestHandlerOptions
-	handler RequestHandler
+	//hits is guarded by the server lock
+	hits int
+	//matched is the number of requests that matched the handler matchers, guarded by the server lock
+	matched int
+}
+
+// ordinalRange is the range of ordinals, among the requests matching the handler matchers, of the requests the handler handles
+type ordinalRange struct {
+	from int
+	//to is 0 if the range has no upper bound
+	to int
+}
+
+func (o *ordinalRange) contains(ordinal int) bool {
+	return ordinal >= o.from && (o.to == 0 || ordinal <= o.to)
+}
+
+func (o *ordinalRange) String() string {
+	switch o.to {
+	case o.from:
+		return fmt.Sprintf("%d", o.from)
+	case 0:
+		return fmt.Sprintf("%d-", o.from)
+	}
+	return fmt.Sprintf("%d-%d", o.from, o.to)
 }
 
 func newRequestHandler(opts ...RequestHandlerOption) (*serverRequestHandler, error) {
@@ -20,35 +50,151 @@ func newRequestHandler(opts ...RequestHandlerOption) (*serverRequestHandler, err
 	}
 
 	return &serverRequestHandler{
+		id:      atomic.AddInt64(&lastHandlerID, 1),
 		options: options,
-		handler: options.getOrCreateHandler(),
 	}, nil
 }
 
-func (h *serverRequestHandler) shouldHandle(r *http.Request, reqCount int) bool {
-	if h.options.method != "" && h.options.method != r.Method {
-		return false
+// requestMatches holds the failed matchers of each handler that don't depend on the server state, they are evaluated before
+// the request is dispatched so that user matchers such as WithBodyPredicate run without holding the server locks
+type requestMatches map[*serverRequestHandler][]string
+
+// shouldHandle returns true if the handler handles the request, matched is true if the request matched the handler matchers
+// regardless of the handler state, which is what the ordinals of WithOrdinal count.
+// A handler added after the matchers were evaluated does not handle the request
+func (h *serverRequestHandler) shouldHandle(matches requestMatches, reqCount int, scenarios scenarioStates) (handle bool, matched bool) {
+	failed, evaluated := matches[h]
+	if !evaluated || len(failed) != 0 || len(h.options.reqNumMismatches(reqCount)) != 0 {
+		return false, false
 	}
-	if h.options.path != "" && h.options.path != r.URL.Path {
-		return false
+	return len(h.stateMismatches(true, scenarios)) == 0, true
+}
+
+// mismatches returns the handler matchers the request failed and the reasons the handler state prevents it from handling the request
+func (h *serverRequestHandler) mismatches(matches requestMatches, reqCount int, scenarios scenarioStates) []string {
+	failed := append(append([]string{}, matches[h]...), h.options.reqNumMismatches(reqCount)...)
+	return append(failed, h.stateMismatches(len(failed) == 0, scenarios)...)
+}
+
+// stateMismatches returns the reasons the handler state prevents it from handling a request, matched is true if the request matched the handler matchers
+func (h *serverRequestHandler) stateMismatches(matched bool, scenarios scenarioStates) []string {
+	failed := []string{}
+	//the ordinal is checked only if the request matches the matchers, as only such requests are counted
+	if ordinal := h.matched + 1; h.options.ordinal != nil && matched && !h.options.ordinal.contains(ordinal) {
+		failed = append(failed, fmt.Sprintf("ordinal: expected %s got %d", h.options.ordinal, ordinal))
 	}
-	if h.options.pathPrefix != "" && !strings.HasPrefix(r.URL.Path, h.options.pathPrefix) {
-		return false
+	if scenario := h.options.scenario; scenario != nil && scenario.requiredState != "" {
+		if state := scenarios.get(scenario.name); state != scenario.requiredState {
+			failed = append(failed, fmt.Sprintf("scenario %s: expected state %s got %s", scenario.name, scenario.requiredState, state))
+		}
 	}
-	if h.options.pathSuffix != "" && !strings.HasSuffix(r.URL.Path, h.options.pathSuffix) {
-		return false
+	//if handler is configured with responses array and served all responses, it does not handle more requests
+	if h.options.responses != nil && len(h.options.responses) == 0 {
+		failed = append(failed, "responses: all responses were served")
 	}
-	if h.options.reqNum != 0 && h.options.reqNum != reqCount {
-		return false
+	return failed
+}
+
+// matchRequest checks the request against the handler matchers only, ignoring the handler state
+func (o *requestHandlerOptions) matchRequest(r *http.Request, reqBody string, reqCount int) bool {
+	return len(o.mismatches(r, reqBody, reqCount)) == 0
+}
+
+// mismatches returns a description of each handler matcher the request failed
+func (o *requestHandlerOptions) mismatches(r *http.Request, reqBody string, reqCount int) []string {
+	return append(o.requestMismatches(r, reqBody), o.reqNumMismatches(reqCount)...)
+}
+
+// reqNumMismatches returns the request number mismatch, the only matcher that depends on the server state
+func (o *requestHandlerOptions) reqNumMismatches(reqCount int) []string {
+	if o.reqNum != 0 && o.reqNum != reqCount {
+		return []string{fmt.Sprintf("request number: expected %d got %d", o.reqNum, reqCount)}
 	}
-	//if handler is configured with responses array and served all responses, return false
-	if h.options.responses != nil && len(h.options.responses) == 0 {
-		return false
+	return nil
+}
+
+// requestMismatches returns a description of each handler matcher the request failed, except the request number
+func (o *requestHandlerOptions) requestMismatches(r *http.Request, reqBody string) []string {
+	failed := []string{}
+	if o.method != "" && o.method != r.Method {
+		failed = append(failed, fmt.Sprintf("method: expected %s got %s", o.method, r.Method))
+	}
+	if o.path != "" && o.path != r.URL.Path {
+		failed = append(failed, fmt.Sprintf("path: expected %s got %s", o.path, r.URL.Path))
+	}
+	if o.pathPrefix != "" && !strings.HasPrefix(r.URL.Path, o.pathPrefix) {
+		failed = append(failed, fmt.Sprintf("path prefix: expected %s got %s", o.pathPrefix, r.URL.Path))
+	}
+	if o.pathSuffix != "" && !strings.HasSuffix(r.URL.Path, o.pathSuffix) {
+		failed = append(failed, fmt.Sprintf("path suffix: expected %s got %s", o.pathSuffix, r.URL.Path))
+	}
+	if o.pathPattern != nil && !o.pathPattern.match(r.URL.Path) {
+		failed = append(failed, fmt.Sprintf("%s: expected %s got %s", o.pathPattern.kind, o.pathPattern.pattern, r.URL.Path))
+	}
+	for _, matcher := range o.matchers {
+		if !matcher.match(r, reqBody) {
+			failed = append(failed, matcher.description)
+		}
+	}
+	return failed
+}
+
+// serve runs the handler with the path parameters captured by its path pattern, next is the sequence response claimed for the request when it was dispatched
+func (h *serverRequestHandler) serve(w http.ResponseWriter, r *http.Request, reqBody string, next *Response) {
+	if h.options.pathPattern != nil {
+		r = withPathParams(r, h.options.pathPattern.params(r.URL.Path))
+	}
+	if h.options.handler != nil {
+		h.options.handler(w, r, reqBody)
+		return
+	}
+	h.options.respond(w, r, reqBody, next)
+}
+
+// claimResponse pops the next response of the handler responses sequence, nil if the handler has no sequence, the caller holds the server lock
+func (h *serverRequestHandler) claimResponse() *Response {
+	if len(h.options.responses) == 0 {
+		return nil
+	}
+	next := h.options.responses[0]
+	h.options.responses = h.options.responses[1:]
+	return &next
+}
+
+// specificity scores how narrow the handler matchers are, used to select the handler in first match routing
+func (h *serverRequestHandler) specificity() int {
+	score := 0
+	switch {
+	case h.options.path != "":
+		score += 3
+	case h.options.pathPattern != nil:
+		score += 2
+	case h.options.pathPrefix != "" || h.options.pathSuffix != "":
+		score++
+	}
+	if h.options.method != "" {
+		score++
+	}
+	if h.options.reqNum != 0 || h.options.ordinal != nil {
+		score += 4
+	}
+	return score + len(h.options.matchers)
+}
+
+// selectFirstMatch returns the handler with the highest priority, ties are broken by the most specific handler and then by the latest added
+func selectFirstMatch(handlers []*serverRequestHandler) *serverRequestHandler {
+	var selected *serverRequestHandler
+	for _, handler := range handlers {
+		if selected == nil ||
+			handler.options.priority > selected.options.priority ||
+			handler.options.priority == selected.options.priority && handler.specificity() >= selected.specificity() {
+			selected = handler
+		}
 	}
-	return true
+	return selected
 }
 
-func (h *serverRequestHandler) handleBefore(other serverRequestHandler) bool {
+func (h *serverRequestHandler) handleBefore(other *serverRequestHandler) bool {
 	if h.options.reqNum == 0 && other.options.reqNum != 0 {
 		return true
 	}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// requestMatcher is an additional condition a request must meet to be handled by a handler
+type requestMatcher struct {
+	//description is reported when the request does not match
+	description string
+	match       func(r *http.Request, reqBody string) bool
+}
+
+func queryParamMatcher(key, value string) requestMatcher {
+	return requestMatcher{
+		description: fmt.Sprintf("query parameter %s: expected %s", key, value),
+		match: func(r *http.Request, reqBody string) bool {
+			for _, v := range r.URL.Query()[key] {
+				if v == value {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+func queryParamPresentMatcher(key string) requestMatcher {
+	return requestMatcher{
+		description: fmt.Sprintf("query parameter %s: expected to be present", key),
+		match: func(r *http.Request, reqBody string) bool {
+			_, ok := r.URL.Query()[key]
+			return ok
+		},
+	}
+}
+
+func headerMatcher(key, value string) requestMatcher {
+	return requestMatcher{
+		description: fmt.Sprintf("header %s: expected %s", key, value),
+		match: func(r *http.Request, reqBody string) bool {
+			for _, v := range r.Header.Values(key) {
+				if v == value {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+func jsonBodyMatcher(description string, match func(body interface{}) bool) requestMatcher {
+	return requestMatcher{
+		description: description,
+		match: func(r *http.Request, reqBody string) bool {
+			var body interface{}
+			if err := json.Unmarshal([]byte(reqBody), &body); err != nil {
+				return false
+			}
+			return match(body)
+		},
+	}
+}
+
+// normalizeJSON converts a go value to the generic representation produced by json.Unmarshal into an interface{}
+func normalizeJSON(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var normalized interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+// jsonContains checks that actual contains expected, objects may have additional keys
+// and each element of an expected array must match an element of the actual array
+func jsonContains(actual, expected interface{}) bool {
+	switch expectedValue := expected.(type) {
+	case map[string]interface{}:
+		actualMap, ok := actual.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for k, v := range expectedValue {
+			actualValue, ok := actualMap[k]
+			if !ok || !jsonContains(actualValue, v) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		actualSlice, ok := actual.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, v := range expectedValue {
+			found := false
+			for _, actualValue := range actualSlice {
+				if jsonContains(actualValue, v) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(actual, expected)
+	}
+}
+
+// jsonPathLookup returns the value at a simple JSONPath expression made of fields and array indexes, e.g. $.query.bool.must[0].term
+func jsonPathLookup(value interface{}, path string) (interface{}, bool) {
+	segments, err := parseJSONPath(path)
+	if err != nil {
+		return nil, false
+	}
+	for _, segment := range segments {
+		switch current := value.(type) {
+		case map[string]interface{}:
+			next, ok := current[segment]
+			if !ok {
+				return nil, false
+			}
+			value = next
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(current) {
+				return nil, false
+			}
+			value = current[index]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// parseJSONPath splits a JSONPath expression into field names and array indexes
+func parseJSONPath(path string) ([]string, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("json path %s must start with $", path)
+	}
+	segments := []string{}
+	rest := path[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("json path %s has an empty field name", path)
+			}
+			segments = append(segments, rest[:end])
+			rest = rest[end:]
+		case '[':
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				return nil, fmt.Errorf("json path %s has an unclosed bracket", path)
+			}
+			segment := strings.Trim(rest[1:end], `'"`)
+			if segment == "" {
+				return nil, fmt.Errorf("json path %s has an empty index", path)
+			}
+			segments = append(segments, segment)
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("json path %s is invalid at %s", path, rest)
+		}
+	}
+	return segments, nil
+}
+
+func webSocketUpgradeMatcher() requestMatcher {
+	return requestMatcher{
+		description: "websocket: expected an upgrade request",
+		match: func(r *http.Request, reqBody string) bool {
+			return websocket.IsWebSocketUpgrade(r)
+		},
+	}
+}
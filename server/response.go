@@ -0,0 +1,109 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+const (
+	contentTypeHeader     = "Content-Type"
+	contentEncodingHeader = "Content-Encoding"
+	jsonContentType       = "application/json"
+)
+
+// Response is a response served by a handler
+type Response struct {
+	//StatusCode of the response, 0 keeps the handler status code or the default 200
+	StatusCode int
+	//Headers are added to the response on top of the handler and server headers
+	Headers map[string]string
+	//Body is written as is
+	Body []byte
+	//JSON if set, is encoded as the body of the response, can't be set with Body
+	JSON interface{}
+	//Template if set, is a text/template rendered with the TemplateData of the request as the body of the response, can't be set with Body or JSON
+	Template string
+
+	template *responseTemplate
+}
+
+// prepare validates the status code, encodes the JSON value of the response into its body and parses its template
+func (r *Response) prepare() error {
+	if r.StatusCode != 0 && (r.StatusCode < 100 || r.StatusCode > 999) {
+		return fmt.Errorf("invalid status code %d", r.StatusCode)
+	}
+	if r.Template != "" {
+		if len(r.Body) != 0 || r.JSON != nil {
+			return fmt.Errorf("template can't be set with body or JSON")
+		}
+		tmpl, err := parseResponseTemplate(r.Template)
+		if err != nil {
+			return err
+		}
+		r.template = tmpl
+		return nil
+	}
+	if r.JSON == nil {
+		return nil
+	}
+	if len(r.Body) != 0 {
+		return fmt.Errorf("body and JSON can't be set together")
+	}
+	body, err := json.Marshal(r.JSON)
+	if err != nil {
+		return fmt.Errorf("failed to encode JSON response: %v", err)
+	}
+	r.Body = body
+	r.JSON = nil
+	headers := map[string]string{contentTypeHeader: jsonContentType}
+	for k, v := range r.Headers {
+		headers[k] = v
+	}
+	r.Headers = headers
+	return nil
+}
+
+// merge returns the response with the status code, headers and body of other taking precedence
+func (r Response) merge(other Response) Response {
+	if other.StatusCode != 0 {
+		r.StatusCode = other.StatusCode
+	}
+	headers := map[string]string{}
+	for k, v := range r.Headers {
+		headers[k] = v
+	}
+	for k, v := range other.Headers {
+		headers[k] = v
+	}
+	r.Headers = headers
+	if len(other.Body) != 0 || other.template != nil {
+		r.Body, r.Template, r.template = other.Body, other.Template, other.template
+	}
+	return r
+}
+
+// render renders the template of the response into its body
+func (r Response) render(req *http.Request, reqBody string) (Response, error) {
+	if r.template == nil {
+		return r, nil
+	}
+	body, err := r.template.render(req, reqBody)
+	if err != nil {
+		return r, err
+	}
+	r.Body = body
+	return r, nil
+}
+
+func (r Response) write(w http.ResponseWriter) {
+	for k, v := range r.Headers {
+		w.Header().Set(k, v)
+	}
+	if r.StatusCode != 0 {
+		w.WriteHeader(r.StatusCode)
+	}
+	if len(r.Body) != 0 {
+		w.Write(r.Body)
+	}
+}
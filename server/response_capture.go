@@ -0,0 +1,138 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"unicode/utf8"
+)
+
+// base64Encoding is the encoding of bodies that are not valid UTF-8 in JSON files, as encoding/json would replace their invalid bytes
+const base64Encoding = "base64"
+
+// RecordedResponse is a response written by the server, captured in the journal and the requests records
+type RecordedResponse struct {
+	StatusCode int                 `json:"status"`
+	Headers    map[string][]string `json:"headers,omitempty"`
+	Body       string              `json:"body,omitempty"`
+}
+
+// recordedResponseJSON is the JSON form of RecordedResponse, a body that is not valid UTF-8 is base64 encoded
+type recordedResponseJSON struct {
+	StatusCode   int                 `json:"status"`
+	Headers      map[string][]string `json:"headers,omitempty"`
+	Body         string              `json:"body,omitempty"`
+	BodyEncoding string              `json:"bodyEncoding,omitempty"`
+}
+
+func (r RecordedResponse) MarshalJSON() ([]byte, error) {
+	body, encoding := encodeBody(r.Body)
+	return json.Marshal(&recordedResponseJSON{StatusCode: r.StatusCode, Headers: r.Headers, Body: body, BodyEncoding: encoding})
+}
+
+func (r *RecordedResponse) UnmarshalJSON(data []byte) error {
+	decoded := recordedResponseJSON{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	body, err := decodeBody(decoded.Body, decoded.BodyEncoding)
+	if err != nil {
+		return err
+	}
+	*r = RecordedResponse{StatusCode: decoded.StatusCode, Headers: decoded.Headers, Body: body}
+	return nil
+}
+
+// encodeBody returns the body as is if it is valid UTF-8, otherwise base64 encoded with the base64 encoding
+func encodeBody(body string) (string, string) {
+	if utf8.ValidString(body) {
+		return body, ""
+	}
+	return base64.StdEncoding.EncodeToString([]byte(body)), base64Encoding
+}
+
+// decodeBody decodes a body encoded by encodeBody
+func decodeBody(text, encoding string) (string, error) {
+	switch encoding {
+	case "":
+		return text, nil
+	case base64Encoding:
+		decoded, err := base64.StdEncoding.DecodeString(text)
+		if err != nil {
+			return "", fmt.Errorf("invalid base64 body: %v", err)
+		}
+		return string(decoded), nil
+	}
+	return "", fmt.Errorf("unsupported body encoding %s", encoding)
+}
+
+// responseCapture passes the response through to the client while capturing a copy of it
+type responseCapture struct {
+	http.ResponseWriter
+	statusCode int
+	headers    http.Header
+	body       bytes.Buffer
+	hijacked   bool
+}
+
+func newResponseCapture(w http.ResponseWriter) *responseCapture {
+	return &responseCapture{ResponseWriter: w}
+}
+
+func (rc *responseCapture) WriteHeader(statusCode int) {
+	if rc.statusCode == 0 {
+		rc.statusCode = statusCode
+		rc.headers = rc.ResponseWriter.Header().Clone()
+	}
+	rc.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rc *responseCapture) Write(b []byte) (int, error) {
+	if rc.statusCode == 0 {
+		rc.WriteHeader(http.StatusOK)
+	}
+	rc.body.Write(b)
+	return rc.ResponseWriter.Write(b)
+}
+
+func (rc *responseCapture) Flush() {
+	if rc.statusCode == 0 {
+		rc.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := rc.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (rc *responseCapture) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rc.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil {
+		rc.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// recordedResponse returns the captured response, nil if the connection was hijacked before a response was written
+func (rc *responseCapture) recordedResponse() *RecordedResponse {
+	if rc.hijacked && rc.statusCode == 0 {
+		return nil
+	}
+	statusCode, headers := rc.statusCode, rc.headers
+	if statusCode == 0 {
+		//nothing was written, the server responds with an empty 200
+		statusCode, headers = http.StatusOK, rc.ResponseWriter.Header().Clone()
+	}
+	return &RecordedResponse{
+		StatusCode: statusCode,
+		Headers:    headers,
+		Body:       rc.body.String(),
+	}
+}
@@ -0,0 +1,23 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestStatusResponsesValidation(t *testing.T) {
+	ts := NewTestServerWithCleanup(t)
+	for _, statusCode := range []int{42, 1000, -1} {
+		if _, err := ts.AddHandler(WithPath("/a"), WithStatusResponses([]Response{{StatusCode: http.StatusOK}, {StatusCode: statusCode}})); err == nil {
+			t.Errorf("expected an error for status code %d", statusCode)
+		}
+	}
+	if _, err := ts.AddHandler(WithPath("/a"), WithStatusResponses([]Response{{}, {StatusCode: 599}})); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []int{http.StatusOK, 599} {
+		if status := doRequest(t, http.MethodGet, ts.GetURL()+"/a").status; status != expected {
+			t.Errorf("expected status %d got %d", expected, status)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+// ScenarioStarted is the initial state of every scenario
+const ScenarioStarted = "Started"
+
+// handlerScenario is the scenario state a handler requires and the state it moves the scenario to
+type handlerScenario struct {
+	name          string
+	requiredState string
+	newState      string
+}
+
+// scenarioStates holds the current state of each scenario by name
+type scenarioStates map[string]string
+
+func (s scenarioStates) get(name string) string {
+	if state, ok := s[name]; ok {
+		return state
+	}
+	return ScenarioStarted
+}
+
+// transition moves the scenario to the new state of the handler scenario, if set
+func (s scenarioStates) transition(scenario *handlerScenario) {
+	if scenario == nil || scenario.newState == "" {
+		return
+	}
+	s[scenario.name] = scenario.newState
+}
+
+func (ts *mockTestingServer) GetScenarioState(name string) string {
+	ts.mux.Lock()
+	defer ts.mux.Unlock()
+	return ts.scenarios.get(name)
+}
+
+func (ts *mockTestingServer) SetScenarioState(name string, state string) {
+	ts.mux.Lock()
+	defer ts.mux.Unlock()
+	ts.scenarios[name] = state
+}
+
+func (ts *mockTestingServer) ResetScenarios() {
+	ts.mux.Lock()
+	defer ts.mux.Unlock()
+	ts.scenarios = scenarioStates{}
+}
@@ -2,6 +2,9 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -9,7 +12,10 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"sort"
+	"strings"
 	"sync"
+	"testing"
+	"time"
 )
 
 const localHost = "127.0.0.1"
@@ -19,17 +25,47 @@ type TestServer interface {
 	GetURL() string
 	//get server port
 	GetPort() int
+	//get an http client trusting the server certificate, with a client certificate if mutual TLS is enabled with the generated CA
+	Client() *http.Client
+	//get the pool of certificates trusted to verify the server certificate, nil if TLS is not enabled
+	CertPool() *x509.CertPool
+	//issue a client certificate signed by the generated CA for mutual TLS, error is returned if the CA was not generated (see WithGeneratedCA)
+	IssueClientCertificate(commonName string) (tls.Certificate, error)
 	//get server port as string
 	GetPortAsString() string
-	//get the current number of requests received by the server
+	//get the current number of requests received by the server, the number of the last request matched by WithRequestNumber
 	GetRequestCount() int
+	//get all the requests received by the server in the order they were received
+	GetRequests() []RecordedRequest
+	//get the received requests that match the given options, matching is done the same way as for handlers added with AddHandler
+	FindRequests(matchers ...RequestHandlerOption) ([]RecordedRequest, error)
+	//wait until a request matching the given options is received, requests received before the call are matched too.
+	//The request is returned as soon as it is received, before its response is recorded. On timeout the error describes the received requests
+	WaitForRequest(ctx context.Context, matchers ...RequestHandlerOption) (RecordedRequest, error)
+	//wait until n requests matching the given options are received, like WaitForRequest
+	WaitForRequests(ctx context.Context, n int, matchers ...RequestHandlerOption) ([]RecordedRequest, error)
 	//SetOption sets a new option to the server, error is return if the option cannot be modified
 	SetOption(opt ServerOption) error
 	//Adds a request handler to the server for optional matching method, path and request number if specified.
 	//Empty strings for method/path or 0 for request number behaves like a wildcard, handler with empty method,path and request count of 0 will be called on each request
-	AddHandler(opts ...RequestHandlerOption) error
+	//The returned handle removes, replaces and inspects the handler
+	AddHandler(opts ...RequestHandlerOption) (RegisteredHandler, error)
+	//get handles to the built-in handlers followed by the added handlers
+	GetHandlers() []RegisteredHandler
+	//get the current state of a scenario, scenarios start in the ScenarioStarted state
+	GetScenarioState(name string) string
+	//set the state of a scenario
+	SetScenarioState(name string, state string)
+	//reset all scenarios to the ScenarioStarted state
+	ResetScenarios()
+	//write the requests journal with the responses written by the server to fileName in HAR 1.2 format
+	ExportHAR(fileName string) error
+	//Verify reports an error on t for each handler whose call count expectation (WithTimes, WithAtLeast, WithAtMost, WithNever) was not met and each failed websocket script
+	Verify(t *testing.T) bool
 	//Resets all handlers, the default handlers are not effected
 	ResetHandlers()
+	//get the base URL of the admin API, empty if the admin API is not enabled (see WithAdminAPI and WithAdminPort)
+	GetAdminURL() string
 	//Closes the server
 	Close()
 }
@@ -43,7 +79,10 @@ func NewTestServer(opts ...ServerOption) (TestServer, error) {
 		options:         *options,
 		mux:             &sync.Mutex{},
 		handlersMux:     &sync.RWMutex{},
-		requestHandlers: []serverRequestHandler{},
+		requestHandlers: []*serverRequestHandler{},
+		scenarios:       scenarioStates{},
+		closed:          make(chan struct{}),
+		journalAppended: make(chan struct{}),
 	}
 	if err := ts.startServer(); err != nil {
 		return nil, err
@@ -51,17 +90,47 @@ func NewTestServer(opts ...ServerOption) (TestServer, error) {
 	return ts, nil
 }
 
+// NewTestServerWithCleanup creates a test server that is closed and verified automatically when the test and all its subtests complete
+func NewTestServerWithCleanup(t *testing.T, opts ...ServerOption) TestServer {
+	ts, err := NewTestServer(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ts.Close()
+		ts.Verify(t)
+	})
+	return ts
+}
+
 type mockTestingServer struct {
 	server          *httptest.Server
 	mux             *sync.Mutex
 	options         serverOptions
 	reqCount        int
-	requestHandlers []serverRequestHandler
-	handlersMux     *sync.RWMutex
+	requestHandlers []*serverRequestHandler
+	//handlersMux guards the handlers, the built-in handlers and middleware in options are modified holding both mux and handlersMux
+	//so they can be read holding either of them, mux is always locked first
+	handlersMux *sync.RWMutex
+	journal     []RecordedRequest
+	//journalHandlers are the handlers that served each journal entry
+	journalHandlers [][]*serverRequestHandler
+	//journalAppended is closed and replaced when a request is appended to the journal
+	journalAppended chan struct{}
+	scenarios       scenarioStates
+	closed          chan struct{}
+	closeOnce       sync.Once
+	adminServer     *httptest.Server
+	client          *http.Client
+	certPool        *x509.CertPool
 }
 
 func (ts *mockTestingServer) GetURL() string {
-	return fmt.Sprintf("http://%s:%d", localHost, ts.options.port)
+	scheme := "http"
+	if ts.options.tls {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s:%d", scheme, localHost, ts.options.port)
 }
 
 func (ts *mockTestingServer) GetPort() int {
@@ -75,6 +144,9 @@ func (ts *mockTestingServer) GetPortAsString() string {
 func (ts *mockTestingServer) SetOption(opt ServerOption) error {
 	ts.mux.Lock()
 	defer ts.mux.Unlock()
+	//options such as WithBuiltInHandler modify the built-in handlers, which are read holding either lock
+	ts.handlersMux.Lock()
+	defer ts.handlersMux.Unlock()
 	_, err := applyOptions(&ts.options, true, opt)
 	return err
 }
@@ -85,150 +157,366 @@ func (ts *mockTestingServer) GetRequestCount() int {
 	return ts.reqCount
 }
 
+func (ts *mockTestingServer) GetRequests() []RecordedRequest {
+	ts.mux.Lock()
+	defer ts.mux.Unlock()
+	return copyRecordedRequests(ts.journal)
+}
+
+func (ts *mockTestingServer) FindRequests(matchers ...RequestHandlerOption) ([]RecordedRequest, error) {
+	options, err := makeRequestHandlerOptions(matchers...)
+	if err != nil {
+		return nil, err
+	}
+	result := []RecordedRequest{}
+	ordinal := 0
+	for _, request := range ts.GetRequests() {
+		if options.matchRequest(request.toHTTPRequest(), request.Body, request.RequestNumber) {
+			if ordinal++; options.ordinal == nil || options.ordinal.contains(ordinal) {
+				result = append(result, request)
+			}
+		}
+	}
+	return result, nil
+}
+
 func (ts *mockTestingServer) ResetHandlers() {
 	ts.handlersMux.Lock()
 	defer ts.handlersMux.Unlock()
-	ts.requestHandlers = []serverRequestHandler{}
+	ts.requestHandlers = []*serverRequestHandler{}
 }
 
-func (ts *mockTestingServer) AddHandler(opts ...RequestHandlerOption) error {
+func (ts *mockTestingServer) AddHandler(opts ...RequestHandlerOption) (RegisteredHandler, error) {
+	handler, err := ts.addHandler(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &registeredHandler{ts: ts, id: handler.id}, nil
+}
+
+func (ts *mockTestingServer) addHandler(opts ...RequestHandlerOption) (*serverRequestHandler, error) {
 	ts.handlersMux.Lock()
 	defer ts.handlersMux.Unlock()
 	handler, err := newRequestHandler(opts...)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	ts.requestHandlers = append(ts.requestHandlers, *handler)
-	return nil
+	ts.requestHandlers = append(ts.requestHandlers, handler)
+	return handler, nil
 }
 
 func (ts *mockTestingServer) Close() {
-	if ts.server != nil {
-		ts.server.Close()
-	}
-	ts.server = nil
+	ts.closeOnce.Do(func() {
+		//release hanging and delayed requests so the server can shut down
+		close(ts.closed)
+		if ts.server != nil {
+			ts.server.Close()
+		}
+		if ts.adminServer != nil {
+			ts.adminServer.Close()
+		}
+	})
 }
 
 func (ts *mockTestingServer) startServer() error {
 	if ts.server != nil {
 		return fmt.Errorf("server already started")
 	}
-	l, err := net.Listen("tcp", fmt.Sprintf("%s:%d", localHost, ts.options.port))
+	l, err := net.Listen("tcp", fmt.Sprintf("%s:%d", ts.options.host, ts.options.port))
 	if err != nil {
 		return err
 	}
 	//update port in options in case a new port was allocated
 	ts.options.port = l.Addr().(*net.TCPAddr).Port
-	ts.server = httptest.NewUnstartedServer(http.HandlerFunc(ts.mainHandler))
+	if ts.options.h2c && ts.options.tls {
+		l.Close()
+		return fmt.Errorf("h2c can't be enabled with TLS, use WithHTTP2 for HTTP/2 over TLS")
+	}
+	ts.server = httptest.NewUnstartedServer(ts.httpHandler())
 	if err := ts.server.Listener.Close(); err != nil {
 		return err
 	}
 	ts.server.Listener = l
 
 	if ts.options.tls {
+		if err := ts.options.prepareTLS(); err != nil {
+			l.Close()
+			return err
+		}
+		ts.server.TLS = ts.options.tlsConfig()
+		ts.server.EnableHTTP2 = ts.options.http2
 		ts.server.StartTLS()
+		ts.certPool = ts.options.certPool(ts.server.Certificate())
 	} else {
 		ts.server.Start()
 	}
+	if ts.client, err = ts.newClient(); err != nil {
+		ts.server.Close()
+		return err
+	}
+	if err := ts.startAdminServer(); err != nil {
+		ts.server.Close()
+		return err
+	}
 	return nil
 }
 
 func (ts *mockTestingServer) mainHandler(w http.ResponseWriter, r *http.Request) {
-	ts.mux.Lock()
-	defer ts.mux.Unlock()
-	ts.reqCount++
+	if prefix := ts.options.adminPrefix; prefix != "" && strings.HasPrefix(r.URL.Path, prefix+"/") {
+		//admin requests are not counted or recorded
+		ts.serveAdmin(w, r, strings.TrimPrefix(r.URL.Path, prefix))
+		return
+	}
+	r = withServerClosed(r, ts.closed)
+	receivedAt := time.Now()
 
 	reqBody := ""
 	if body, err := ioutil.ReadAll(r.Body); err == nil {
 		reqBody = string(body)
 		r.Body = ioutil.NopCloser(bytes.NewBuffer(body))
 	}
-	for header, value := range ts.options.headers {
-		w.Header().Set(header, value)
+	dispatched := ts.dispatch(r, reqBody, receivedAt)
+
+	capture := newResponseCapture(w)
+	responded := false
+	//record in a deferred call so that requests aborted by a connection reset are recorded too
+	defer func() {
+		var response *RecordedResponse
+		if responded {
+			response = capture.recordedResponse()
+		}
+		ts.completeRequest(dispatched.journalIndex, response, time.Since(receivedAt))
+		if dispatched.options.record && dispatched.reqNum > dispatched.options.recordAfterReqNum {
+			ts.recordRequest(r, reqBody, dispatched, response)
+		}
+	}()
+	responded = ts.respond(capture, r, reqBody, dispatched)
+}
+
+// handlerCall is a handler selected for a request with the sequence response it claimed, nil if the handler has no responses sequence
+type handlerCall struct {
+	handler  *serverRequestHandler
+	response *Response
+}
+
+// dispatchedRequest is how the server serves a request, decided atomically when the request is received
+// so that the request is served concurrently with other requests without holding the server lock
+type dispatchedRequest struct {
+	reqNum       int
+	journalIndex int
+	//options is a snapshot of the server options when the request was received
+	options    serverOptions
+	middleware []handlerCall
+	handlers   []handlerCall
+	faults     []Fault
+	proxied    bool
+	//nearestMisses are computed for unmatched requests if an unmatched requests policy is set
+	nearestMisses []nearestMiss
+}
+
+// dispatch numbers the request, selects its middleware and handlers, claims their sequence responses, moves the scenarios,
+// picks the faults and appends the request to the journal, all in a single critical section.
+// The handlers matchers that don't depend on the server state are evaluated before it
+func (ts *mockTestingServer) dispatch(r *http.Request, reqBody string, receivedAt time.Time) *dispatchedRequest {
+	matches := ts.evaluateMatchers(r, reqBody)
+	ts.mux.Lock()
+	defer ts.mux.Unlock()
+	ts.handlersMux.RLock()
+	defer ts.handlersMux.RUnlock()
+	//the handlers are matched with the number the request gets if it is counted
+	matchedNum := ts.reqCount + 1
+	//the handlers with an ordinal whose matchers the request matched, counted once the handlers are selected
+	ordinalMatches := []*serverRequestHandler{}
+	handlers := ts.getRequestHandlers(matches, matchedNum, &ordinalMatches)
+	reqNum := 0
+	if !ts.options.builtInRequestsUncounted || !ts.onlyBuiltInHandlers(handlers) {
+		ts.reqCount++
+		reqNum = ts.reqCount
 	}
-	middleware := ts.getMiddleware(r)
-	for _, m := range middleware {
-		m(w, r, reqBody)
+
+	d := &dispatchedRequest{reqNum: reqNum, options: ts.options}
+	d.proxied = ts.options.proxy.shouldProxy(len(handlers))
+	if d.proxied {
+		//the upstream response is served instead of the handlers
+		handlers = nil
+	}
+	record := newRecordedRequest(r, reqBody, reqNum, receivedAt)
+	record.Proxied = d.proxied
+	if !d.proxied {
+		for _, m := range ts.getMiddleware(matches, matchedNum, &ordinalMatches) {
+			d.middleware = append(d.middleware, handlerCall{handler: m, response: m.claimResponse()})
+		}
 	}
-	handlers := ts.getRequestHandlers(r)
+	candidateFaults := append([]Fault{}, ts.options.faults...)
 	for _, handler := range handlers {
-		handler(w, r, reqBody)
+		handler.hits++
+		d.handlers = append(d.handlers, handlerCall{handler: handler, response: handler.claimResponse()})
+		ts.scenarios.transition(handler.options.scenario)
+		record.Handlers = append(record.Handlers, handler.options.describe())
+		candidateFaults = append(candidateFaults, handler.options.faults...)
+	}
+	if len(handlers) == 0 && !d.proxied && (ts.options.unmatchedStatusCode != 0 || ts.options.unmatchedT != nil) {
+		d.nearestMisses = ts.nearestMisses(matches, matchedNum)
+	}
+	for _, handler := range ordinalMatches {
+		handler.matched++
+	}
+	d.faults = pickFaults(ts.options.rand, candidateFaults)
+	record.Faults = faultNames(d.faults)
+	d.journalIndex = len(ts.journal)
+	ts.journal = append(ts.journal, record)
+	ts.journalHandlers = append(ts.journalHandlers, handlers)
+	ts.notifyJournalAppended()
+	return d
+}
+
+// completeRequest sets the response and the duration of a request in the journal
+func (ts *mockTestingServer) completeRequest(journalIndex int, response *RecordedResponse, duration time.Duration) {
+	ts.mux.Lock()
+	defer ts.mux.Unlock()
+	ts.journal[journalIndex].Response = response
+	ts.journal[journalIndex].Duration = duration
+}
+
+// respond runs the middleware and handlers of the request, applying the given faults
+// returns false if no response was written because of a fault or because the client gave up
+func (ts *mockTestingServer) respond(w http.ResponseWriter, r *http.Request, reqBody string, d *dispatchedRequest) bool {
+	if !applyDelays(r.Context(), ts.closed, d.faults) {
+		return false
 	}
-	if ts.options.record && ts.reqCount > ts.options.recordAfterReqNum {
-		ts.recordRequest(r, reqBody, len(handlers))
+	fault := terminalFault(d.faults)
+	var bufferedWriter *bufferedResponseWriter
+	if fault != nil {
+		switch fault.Type {
+		case FaultHang:
+			select {
+			case <-r.Context().Done():
+			case <-ts.closed:
+			}
+			return false
+		case FaultConnectionReset:
+			resetConnection(w)
+			return false
+		case FaultTruncatedBody, FaultMalformedBody:
+			bufferedWriter = &bufferedResponseWriter{ResponseWriter: w}
+			w = bufferedWriter
+		}
 	}
+	if d.proxied {
+		d.options.proxy.serve(w, r)
+	} else {
+		ts.serveHandlers(w, r, reqBody, d)
+	}
+	if bufferedWriter != nil {
+		bufferedWriter.writeWithFault(fault)
+	}
+	return true
 }
 
-func (ts *mockTestingServer) recordRequest(r *http.Request, reqBody string, handlersCount int) {
-	if ts.options.recordOnlyUnhandled && handlersCount > 0 {
+// serveHandlers writes the server headers and runs the middleware and the request handlers
+func (ts *mockTestingServer) serveHandlers(w http.ResponseWriter, r *http.Request, reqBody string, d *dispatchedRequest) {
+	for header, value := range d.options.headers {
+		w.Header().Set(header, value)
+	}
+	for _, m := range d.middleware {
+		m.handler.serve(w, r, reqBody, m.response)
+	}
+	for _, call := range d.handlers {
+		call.handler.serve(w, r, reqBody, call.response)
+	}
+	if len(d.handlers) == 0 {
+		handleUnmatched(w, r, d)
+	}
+}
+
+func (ts *mockTestingServer) recordRequest(r *http.Request, reqBody string, d *dispatchedRequest, response *RecordedResponse) {
+	if d.options.recordOnlyUnhandled && len(d.handlers) > 0 {
 		return
 	}
 
 	var iBody interface{}
 	json.Unmarshal([]byte(reqBody), &iBody)
 
-	record := struct {
-		Body          string              `json:"body,omitempty"`
-		BodyObj       interface{}         `json:"bodyObj,omitempty"`
-		RequestNumber int                 `json:"req_num,omitempty"`
-		Headers       map[string][]string `json:"headers,omitempty"`
-		URL           string              `json:"url,omitempty"`
-		Method        string              `json:"method,omitempty"`
-		HandlersCount int                 `json:"handlers_count"`
-	}{
+	record := requestRecord{
 		Headers:       r.Header,
 		URL:           r.URL.String(),
 		Method:        r.Method,
 		Body:          reqBody,
 		BodyObj:       iBody,
-		RequestNumber: ts.reqCount,
-		HandlersCount: handlersCount,
+		RequestNumber: d.reqNum,
+		HandlersCount: len(d.handlers),
+		Response:      response,
 	}
 	reqBytes, _ := json.MarshalIndent(&record, "", "    ")
-	fileName := fmt.Sprintf("%s/request_%d.json", ts.options.recordFolder, ts.reqCount)
+	fileName := fmt.Sprintf("%s/request_%d.json", d.options.recordFolder, d.reqNum)
 	_ = ioutil.WriteFile(fileName, reqBytes, 0644)
 }
 
-func (ts *mockTestingServer) getMiddleware(r *http.Request) []RequestHandler {
-	middlewareHandlers := []serverRequestHandler{}
-	for _, handler := range ts.options.middleware {
-		if handler.shouldHandle(r, ts.reqCount) {
-			middlewareHandlers = append(middlewareHandlers, handler)
-		}
-	}
+func (ts *mockTestingServer) getMiddleware(matches requestMatches, reqNum int, ordinalMatches *[]*serverRequestHandler) []*serverRequestHandler {
+	middlewareHandlers := ts.selectHandlers(ts.options.middleware, matches, reqNum, ordinalMatches)
 	sort.Slice(middlewareHandlers, func(i, j int) bool {
 		return middlewareHandlers[i].handleBefore(middlewareHandlers[j])
 	})
-	handlers := []RequestHandler{}
-	for _, handler := range middlewareHandlers {
-		handlers = append(handlers, handler.handler)
-	}
-	return handlers
+	return middlewareHandlers
 }
 
-func (ts *mockTestingServer) getRequestHandlers(r *http.Request) []RequestHandler {
-	serverHandlers := []serverRequestHandler{}
-	for i, handler := range ts.options.defaultRequestHandlers {
-		if handler.shouldHandle(r, ts.reqCount) {
-			serverHandlers = append(serverHandlers, handler)
-		}
-		if i == 1000 {
-			continue
-		}
-	}
-	for _, handler := range ts.requestHandlers {
-		if handler.shouldHandle(r, ts.reqCount) {
-			serverHandlers = append(serverHandlers, handler)
-		}
+func (ts *mockTestingServer) getRequestHandlers(matches requestMatches, reqNum int, ordinalMatches *[]*serverRequestHandler) []*serverRequestHandler {
+	serverHandlers := append(ts.selectHandlers(ts.options.defaultRequestHandlers, matches, reqNum, ordinalMatches),
+		ts.selectHandlers(ts.requestHandlers, matches, reqNum, ordinalMatches)...)
+	if ts.options.firstMatchRouting && len(serverHandlers) > 1 {
+		return []*serverRequestHandler{selectFirstMatch(serverHandlers)}
 	}
 	sort.Slice(serverHandlers, func(i, j int) bool {
 		return serverHandlers[i].handleBefore(serverHandlers[j])
 	})
+	return serverHandlers
+}
 
-	handlers := []RequestHandler{}
-	for _, handler := range serverHandlers {
-		handlers = append(handlers, handler.handler)
+// onlyBuiltInHandlers returns true if the request is handled and all its handlers are built-in handlers
+func (ts *mockTestingServer) onlyBuiltInHandlers(handlers []*serverRequestHandler) bool {
+	if len(handlers) == 0 {
+		return false
 	}
+	for _, handler := range handlers {
+		builtIn := false
+		for _, defaultHandler := range ts.options.defaultRequestHandlers {
+			if handler == defaultHandler {
+				builtIn = true
+				break
+			}
+		}
+		if !builtIn {
+			return false
+		}
+	}
+	return true
+}
+
+// evaluateMatchers evaluates the matchers that don't depend on the server state for all the handlers and middleware,
+// the handlers lock is held only to copy the handlers so the matchers can call the TestServer methods
+func (ts *mockTestingServer) evaluateMatchers(r *http.Request, reqBody string) requestMatches {
+	ts.handlersMux.RLock()
+	handlers := append(append(append([]*serverRequestHandler{}, ts.options.middleware...), ts.options.defaultRequestHandlers...), ts.requestHandlers...)
+	ts.handlersMux.RUnlock()
+	matches := requestMatches{}
+	for _, handler := range handlers {
+		matches[handler] = handler.options.requestMismatches(r, reqBody)
+	}
+	return matches
+}
 
-	return handlers
+// selectHandlers returns the handlers that handle the request, the handlers with an ordinal whose matchers the request matched
+// are appended to ordinalMatches so their ordinal is counted
+func (ts *mockTestingServer) selectHandlers(handlers []*serverRequestHandler, matches requestMatches, reqNum int, ordinalMatches *[]*serverRequestHandler) []*serverRequestHandler {
+	selected := []*serverRequestHandler{}
+	for _, handler := range handlers {
+		handle, matched := handler.shouldHandle(matches, reqNum, ts.scenarios)
+		if handle {
+			selected = append(selected, handler)
+		}
+		if matched && handler.options.ordinal != nil {
+			*ordinalMatches = append(*ordinalMatches, handler)
+		}
+	}
+	return selected
 }
@@ -0,0 +1,341 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The tests of this file are meant to be run with the race detector, go test -race
+
+const (
+	concurrentClients  = 20
+	requestsPerClient  = 15
+	concurrentRequests = concurrentClients * requestsPerClient
+)
+
+// runClients runs concurrent clients, each calling request requestsPerClient times
+func runClients(t *testing.T, request func(client, i int)) {
+	t.Helper()
+	var wg sync.WaitGroup
+	for c := 0; c < concurrentClients; c++ {
+		wg.Add(1)
+		go func(client int) {
+			defer wg.Done()
+			for i := 0; i < requestsPerClient; i++ {
+				request(client, i)
+			}
+		}(c)
+	}
+	wg.Wait()
+}
+
+type testResponse struct {
+	status int
+	body   []byte
+}
+
+// doRequest sends a request without a body and returns the response, errors are reported with t.Error
+// rather than t.Fatal so it can be called from the client goroutines
+func doRequest(t *testing.T, method, url string) testResponse {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Error(err)
+		return testResponse{}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Error(err)
+		return testResponse{}
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Error(err)
+	}
+	return testResponse{status: resp.StatusCode, body: body}
+}
+
+func TestConcurrentResponsesSequences(t *testing.T) {
+	const sequenceLength = concurrentRequests / 2
+	bodies := [][]byte{}
+	statusResponses := []Response{}
+	for i := 0; i < sequenceLength; i++ {
+		bodies = append(bodies, []byte(strconv.Itoa(i)))
+		statusResponses = append(statusResponses, Response{StatusCode: http.StatusCreated + i%2, Body: []byte(strconv.Itoa(i))})
+	}
+	ts := NewTestServerWithCleanup(t)
+	if _, err := ts.AddHandler(WithPath("/bodies"), WithResponses(bodies)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.AddHandler(WithPath("/statuses"), WithStatusResponses(statusResponses)); err != nil {
+		t.Fatal(err)
+	}
+
+	var mux sync.Mutex
+	served := map[string][]testResponse{}
+	runClients(t, func(client, i int) {
+		for _, path := range []string{"/bodies", "/statuses"} {
+			resp := doRequest(t, http.MethodGet, ts.GetURL()+path)
+			mux.Lock()
+			served[path] = append(served[path], resp)
+			mux.Unlock()
+		}
+	})
+
+	for _, path := range []string{"/bodies", "/statuses"} {
+		seen := map[string]bool{}
+		for _, resp := range served[path] {
+			if len(resp.body) == 0 {
+				//the sequence was exhausted, the request was not matched
+				continue
+			}
+			if seen[string(resp.body)] {
+				t.Errorf("%s: response %s was served twice", path, resp.body)
+			}
+			seen[string(resp.body)] = true
+			if path == "/statuses" {
+				i, _ := strconv.Atoi(string(resp.body))
+				if expected := http.StatusCreated + i%2; resp.status != expected {
+					t.Errorf("%s: response %s served with status %d, expected %d", path, resp.body, resp.status, expected)
+				}
+			}
+		}
+		if len(seen) != sequenceLength {
+			t.Errorf("%s: expected %d responses served got %d", path, sequenceLength, len(seen))
+		}
+	}
+	if count := ts.GetRequestCount(); count != 2*concurrentRequests {
+		t.Errorf("expected %d requests got %d", 2*concurrentRequests, count)
+	}
+}
+
+func TestConcurrentScenarioTransitions(t *testing.T) {
+	ts := NewTestServerWithCleanup(t)
+	if _, err := ts.AddHandler(WithName("on"), WithPath("/toggle"), WithScenario("switch", ScenarioStarted), WithNewScenarioState("on")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.AddHandler(WithName("off"), WithPath("/toggle"), WithScenario("switch", "on"), WithNewScenarioState(ScenarioStarted)); err != nil {
+		t.Fatal(err)
+	}
+	runClients(t, func(client, i int) {
+		doRequest(t, http.MethodPost, ts.GetURL()+"/toggle")
+	})
+
+	requests := ts.GetRequests()
+	if len(requests) != concurrentRequests {
+		t.Fatalf("expected %d requests got %d", concurrentRequests, len(requests))
+	}
+	//each request sees the state the previous request moved the scenario to
+	for i, request := range requests {
+		expected := "on"
+		if i%2 == 1 {
+			expected = "off"
+		}
+		if len(request.Handlers) != 1 || request.Handlers[0] != expected {
+			t.Fatalf("request %d expected to be handled by %s got %v", i+1, expected, request.Handlers)
+		}
+	}
+	if state := ts.GetScenarioState("switch"); state != ScenarioStarted {
+		t.Errorf("expected state %s got %s", ScenarioStarted, state)
+	}
+}
+
+func TestHandlerCallingServerMethods(t *testing.T) {
+	var ts TestServer
+	ts = NewTestServerWithCleanup(t, WithBuiltInHandler(WithPath("/count"), WithHandler(func(w http.ResponseWriter, r *http.Request, reqBody string) {
+		//must not deadlock, the handler runs without holding the server lock
+		ts.GetRequests()
+		ts.GetScenarioState("any")
+		fmt.Fprint(w, ts.GetRequestCount())
+	})))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runClients(t, func(client, i int) {
+			resp := doRequest(t, http.MethodGet, ts.GetURL()+"/count")
+			if count, err := strconv.Atoi(string(resp.body)); err != nil || count < 1 || count > concurrentRequests {
+				t.Errorf("unexpected request count %q", resp.body)
+			}
+		})
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("requests did not complete, handlers calling the server deadlocked")
+	}
+}
+
+func TestSlowHandlerDoesNotBlockOtherRequests(t *testing.T) {
+	release := make(chan struct{})
+	ts := NewTestServerWithCleanup(t)
+	if _, err := ts.AddHandler(WithPath("/slow"), WithHandler(func(w http.ResponseWriter, r *http.Request, reqBody string) {
+		<-release
+	})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.AddHandler(WithPath("/fast"), WithStatusCode(http.StatusAccepted)); err != nil {
+		t.Fatal(err)
+	}
+
+	slowDone := make(chan struct{})
+	go func() {
+		defer close(slowDone)
+		doRequest(t, http.MethodGet, ts.GetURL()+"/slow")
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := ts.WaitForRequest(ctx, WithPath("/slow")); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	for i := 0; i < 10; i++ {
+		resp, err := client.Get(ts.GetURL() + "/fast")
+		if err != nil {
+			t.Fatalf("request blocked by the slow handler: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			t.Errorf("expected status %d got %d", http.StatusAccepted, resp.StatusCode)
+		}
+	}
+	close(release)
+	<-slowDone
+}
+
+func TestConcurrentServerMethods(t *testing.T) {
+	//the expectation is met at any time, so Verify passes while the requests are served
+	ts := NewTestServerWithCleanup(t, WithBuiltInHandler(WithPath("/"), WithAtMost(concurrentRequests)))
+	const iterations = 100
+	var wg sync.WaitGroup
+	background := func(f func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				f(i)
+			}
+		}()
+	}
+	background(func(i int) {
+		if err := ts.SetOption(WithHeaders(map[string]string{"X-Iteration": strconv.Itoa(i)})); err != nil {
+			t.Error(err)
+		}
+	})
+	background(func(i int) {
+		if _, err := ts.AddHandler(WithPath(fmt.Sprintf("/added/%d", i)), WithStatusResponses([]Response{{StatusCode: http.StatusCreated}})); err != nil {
+			t.Error(err)
+		}
+	})
+	background(func(i int) {
+		if i%10 == 0 {
+			ts.ResetHandlers()
+		}
+		ts.Verify(t)
+	})
+	background(func(i int) {
+		ts.GetRequests()
+		ts.GetHandlers()
+		if _, err := ts.FindRequests(WithPath("/")); err != nil {
+			t.Error(err)
+		}
+	})
+	runClients(t, func(client, i int) {
+		resp := doRequest(t, http.MethodGet, ts.GetURL()+"/")
+		if resp.status != http.StatusOK {
+			t.Errorf("expected status %d got %d", http.StatusOK, resp.status)
+		}
+	})
+	wg.Wait()
+	if hits := ts.GetHandlers()[0].HitCount(); hits != concurrentRequests {
+		t.Errorf("expected %d hits got %d", concurrentRequests, hits)
+	}
+}
+
+func TestConcurrentClose(t *testing.T) {
+	ts := NewTestServerWithCleanup(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ts.Close()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestMatcherCallingServerMethods(t *testing.T) {
+	var ts TestServer
+	ts = NewTestServerWithCleanup(t)
+	//must not deadlock, the matchers run without holding the server locks
+	predicate := func(reqBody string) bool {
+		ts.GetRequestCount()
+		ts.GetRequests()
+		ts.GetHandlers()
+		return true
+	}
+	if _, err := ts.AddHandler(WithPath("/match"), WithBodyPredicate(predicate), WithStatusCode(http.StatusAccepted)); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runClients(t, func(client, i int) {
+			resp := doRequest(t, http.MethodGet, ts.GetURL()+"/match")
+			if resp.status != http.StatusAccepted {
+				t.Errorf("expected status %d got %d", http.StatusAccepted, resp.status)
+			}
+		})
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("requests did not complete, matchers calling the server deadlocked")
+	}
+}
+
+func TestSlowMatcherDoesNotBlockOtherRequests(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	ts := NewTestServerWithCleanup(t)
+	if _, err := ts.AddHandler(WithPath("/slow"), WithBodyPredicate(func(reqBody string) bool {
+		if reqBody == "slow" {
+			close(entered)
+			<-release
+		}
+		return true
+	})); err != nil {
+		t.Fatal(err)
+	}
+
+	slowDone := make(chan error, 1)
+	go func() {
+		resp, err := http.Post(ts.GetURL()+"/slow", "text/plain", strings.NewReader("slow"))
+		if err == nil {
+			resp.Body.Close()
+		}
+		slowDone <- err
+	}()
+	<-entered
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	for i := 0; i < 10; i++ {
+		resp, err := client.Get(ts.GetURL() + "/fast")
+		if err != nil {
+			t.Fatalf("request blocked by the slow matcher: %v", err)
+		}
+		resp.Body.Close()
+	}
+	close(release)
+	if err := <-slowDone; err != nil {
+		t.Error(err)
+	}
+}
@@ -1,8 +1,16 @@
 package server
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io/fs"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
 )
 
 type ServerOption func(opts *serverOptions, isUpdate bool) error
@@ -16,7 +24,7 @@ var WithBuiltInHandler = func(opts ...RequestHandlerOption) ServerOption {
 		if handler, err := newRequestHandler(opts...); err != nil {
 			return err
 		} else {
-			o.defaultRequestHandlers = append(o.defaultRequestHandlers, *handler)
+			o.defaultRequestHandlers = append(o.defaultRequestHandlers, handler)
 		}
 		return nil
 	}
@@ -30,7 +38,7 @@ var WithBuiltInMiddlewareHandler = func(opts ...RequestHandlerOption) ServerOpti
 		if handler, err := newRequestHandler(opts...); err != nil {
 			return err
 		} else {
-			o.middleware = append(o.middleware, *handler)
+			o.middleware = append(o.middleware, handler)
 		}
 		return nil
 	}
@@ -39,9 +47,15 @@ var WithBuiltInMiddlewareHandler = func(opts ...RequestHandlerOption) ServerOpti
 // WithHeaders option adds headers to each response
 var WithHeaders = func(headers map[string]string) ServerOption {
 	return func(o *serverOptions, isUpdate bool) error {
+		//the headers are copied so requests being served keep the headers they were dispatched with
+		merged := make(map[string]string, len(o.headers)+len(headers))
+		for k, v := range o.headers {
+			merged[k] = v
+		}
 		for k, v := range headers {
-			o.headers[k] = v
+			merged[k] = v
 		}
+		o.headers = merged
 		return nil
 	}
 }
@@ -58,6 +72,22 @@ var WithPort = func(port int) ServerOption {
 	}
 }
 
+// WithHost option sets the address the server listens on, e.g. 0.0.0.0 to accept connections from other hosts, the default is 127.0.0.1
+// The server URL always uses 127.0.0.1
+// This option cannot be changed after the server is created
+var WithHost = func(host string) ServerOption {
+	return func(o *serverOptions, isUpdate bool) error {
+		if isUpdate {
+			return fmt.Errorf("host can't be updated")
+		}
+		if host == "" {
+			return fmt.Errorf("host must not be empty")
+		}
+		o.host = host
+		return nil
+	}
+}
+
 // WithTLS option enables TLS for the server
 // This option cannot be changed after the server is created
 var WithTLS = func() ServerOption {
@@ -70,6 +100,91 @@ var WithTLS = func() ServerOption {
 	}
 }
 
+// WithHTTP2 option enables TLS and negotiates HTTP/2 with ALPN, clients that do not support HTTP/2 fall back to HTTP/1.1
+// This option cannot be changed after the server is created
+var WithHTTP2 = func() ServerOption {
+	return func(o *serverOptions, isUpdate bool) error {
+		if isUpdate {
+			return fmt.Errorf("http2 option can't be updated")
+		}
+		o.tls = true
+		o.http2 = true
+		return nil
+	}
+}
+
+// WithH2C option enables cleartext HTTP/2 (h2c) with prior knowledge or an HTTP/1.1 upgrade, HTTP/1.1 requests are still served
+// This option cannot be combined with TLS and cannot be changed after the server is created
+var WithH2C = func() ServerOption {
+	return func(o *serverOptions, isUpdate bool) error {
+		if isUpdate {
+			return fmt.Errorf("h2c option can't be updated")
+		}
+		o.h2c = true
+		return nil
+	}
+}
+
+// WithTLSCertificate option enables TLS for the server with the given PEM encoded certificate chain and private key
+// This option cannot be changed after the server is created
+var WithTLSCertificate = func(certPEM, keyPEM []byte) ServerOption {
+	return func(o *serverOptions, isUpdate bool) error {
+		if isUpdate {
+			return fmt.Errorf("tls certificate option can't be updated")
+		}
+		cert, err := parseCertificate(certPEM, keyPEM)
+		if err != nil {
+			return err
+		}
+		o.tls = true
+		o.ca = nil
+		o.tlsCertificate = cert
+		return nil
+	}
+}
+
+// WithGeneratedCA option enables TLS for the server with a certificate issued by a generated CA for the given subject alternative names,
+// IP addresses and DNS names, 127.0.0.1 and localhost are used if no names are given. The CA is trusted by the server Client and CertPool
+// This option cannot be changed after the server is created
+var WithGeneratedCA = func(sans ...string) ServerOption {
+	return func(o *serverOptions, isUpdate bool) error {
+		if isUpdate {
+			return fmt.Errorf("generated CA option can't be updated")
+		}
+		if len(sans) == 0 {
+			sans = defaultSANs
+		}
+		ca, err := newCertificateAuthority()
+		if err != nil {
+			return fmt.Errorf("failed to generate CA: %v", err)
+		}
+		cert, err := ca.issue(localHost, sans, x509.ExtKeyUsageServerAuth)
+		if err != nil {
+			return fmt.Errorf("failed to issue server certificate: %v", err)
+		}
+		o.tls = true
+		o.ca = ca
+		o.tlsCertificate = &cert
+		return nil
+	}
+}
+
+// WithClientAuth option enables TLS for the server and requires clients to present a certificate verified by clientCAs,
+// if clientCAs is nil the generated CA is used (see WithGeneratedCA and IssueClientCertificate).
+// The common name of the client certificate is recorded in the journal as the client identity
+// This option cannot be changed after the server is created
+var WithClientAuth = func(clientCAs *x509.CertPool) ServerOption {
+	return func(o *serverOptions, isUpdate bool) error {
+		if isUpdate {
+			return fmt.Errorf("client auth option can't be updated")
+		}
+		o.tls = true
+		o.clientAuth = true
+		o.clientCAs = clientCAs
+		return nil
+	}
+}
+
 // WithRecord option enables recording of requests to the server to a specific folder and after a specific number of requests
 var WithRequestsRecorder = func(record bool, recordsFolder string, recordAfterReqNum int, recordOnlyUnhandled bool) ServerOption {
 	return func(o *serverOptions, isUpdate bool) error {
@@ -89,29 +204,227 @@ var WithRequestsRecorder = func(record bool, recordsFolder string, recordAfterRe
 	}
 }
 
+// WithFirstMatchRouting option makes only a single handler respond to each request instead of all the matching handlers,
+// the handler with the highest priority (see WithPriority) is selected, then the most specific one and then the latest added
+var WithFirstMatchRouting = func() ServerOption {
+	return func(o *serverOptions, isUpdate bool) error {
+		o.firstMatchRouting = true
+		return nil
+	}
+}
+
+// WithBuiltInRequestsUncounted option excludes the requests handled only by built-in handlers (e.g. health probes served by
+// WithBuiltInHandler or stub files) from the request count, so they don't shift the request numbers matched by WithRequestNumber.
+// Uncounted requests are journaled with request number 0 and are not recorded by WithRequestsRecorder
+var WithBuiltInRequestsUncounted = func() ServerOption {
+	return func(o *serverOptions, isUpdate bool) error {
+		o.builtInRequestsUncounted = true
+		return nil
+	}
+}
+
+// WithUnmatchedResponse option answers requests that are not matched by any handler with statusCode (e.g. 404 or 501)
+// and a JSON body describing the nearest miss handlers and the matchers each of them failed.
+// Without this option unmatched requests are answered with an empty 200 response
+var WithUnmatchedResponse = func(statusCode int) ServerOption {
+	return func(o *serverOptions, isUpdate bool) error {
+		if statusCode < 100 || statusCode > 999 {
+			return fmt.Errorf("invalid status code %d", statusCode)
+		}
+		o.unmatchedStatusCode = statusCode
+		return nil
+	}
+}
+
+// WithStrictUnmatched option reports an error on t for each request that is not matched by any handler
+var WithStrictUnmatched = func(t *testing.T) ServerOption {
+	return func(o *serverOptions, isUpdate bool) error {
+		if t == nil {
+			return fmt.Errorf("test must be provided for strict unmatched requests")
+		}
+		o.unmatchedT = t
+		return nil
+	}
+}
+
+// WithServerFaults option injects faults into all the server responses, see the Fault constructors for the supported faults
+var WithServerFaults = func(faults ...Fault) ServerOption {
+	return func(o *serverOptions, isUpdate bool) error {
+		if err := validateFaults(faults); err != nil {
+			return err
+		}
+		o.faults = faults
+		return nil
+	}
+}
+
+// WithRandomSeed option seeds the random source used for probabilistic faults and jittered delays, making them reproducible
+var WithRandomSeed = func(seed int64) ServerOption {
+	return func(o *serverOptions, isUpdate bool) error {
+		o.rand = rand.New(rand.NewSource(seed))
+		return nil
+	}
+}
+
+// WithProxy option forwards the requests no handler matched to upstreamURL, or all the requests if proxyAll is true.
+// When recording is enabled with WithRequestsRecorder the upstream responses are recorded alongside the requests,
+// so the recordings can later be served offline with WithReplay
+var WithProxy = func(upstreamURL string, proxyAll bool) ServerOption {
+	return func(o *serverOptions, isUpdate bool) error {
+		proxy, err := newUpstreamProxy(upstreamURL, proxyAll)
+		if err != nil {
+			return err
+		}
+		o.proxy = proxy
+		return nil
+	}
+}
+
+// WithReplay option adds built in handlers serving the responses recorded by WithRequestsRecorder in recordsFolder.
+// Requests are matched to the recordings by method and path, and optionally query and body depending on matching.
+// Identical recorded requests serve their recorded responses in order, after which the handler stops matching.
+// Records without a captured response are answered with an empty 200
+var WithReplay = func(recordsFolder string, matching ReplayMatching) ServerOption {
+	return func(o *serverOptions, isUpdate bool) error {
+		records, err := loadRecords(recordsFolder)
+		if err != nil {
+			return err
+		}
+		exchanges := []replayExchange{}
+		for _, record := range records {
+			exchanges = append(exchanges, replayExchange{method: record.Method, url: record.URL, body: record.Body, response: record.Response})
+		}
+		return withReplayHandlers(o, exchanges, matching)
+	}
+}
+
+// WithHARStubs option adds built in handlers serving the responses of the entries of a HAR 1.2 file,
+// requests are matched to the entries the same way as WithReplay
+var WithHARStubs = func(fileName string, matching ReplayMatching) ServerOption {
+	return func(o *serverOptions, isUpdate bool) error {
+		exchanges, err := loadHARExchanges(fileName)
+		if err != nil {
+			return err
+		}
+		return withReplayHandlers(o, exchanges, matching)
+	}
+}
+
+func withReplayHandlers(o *serverOptions, exchanges []replayExchange, matching ReplayMatching) error {
+	handlersOptions, err := replayHandlersOptions(exchanges, matching)
+	if err != nil {
+		return err
+	}
+	for _, opts := range handlersOptions {
+		handler, err := newRequestHandler(opts...)
+		if err != nil {
+			return err
+		}
+		o.defaultRequestHandlers = append(o.defaultRequestHandlers, handler)
+	}
+	return nil
+}
+
+// WithStubFile option adds built in handlers declared in a YAML or JSON stub file, see the README for the stub file format
+var WithStubFile = func(fileName string) ServerOption {
+	return WithStubsFS(os.DirFS(filepath.Dir(fileName)), filepath.Base(fileName))
+}
+
+// WithStubsDir option adds built in handlers declared in the YAML and JSON stub files of a directory
+var WithStubsDir = func(dir string) ServerOption {
+	return WithStubsFS(os.DirFS(dir))
+}
+
+// WithStubsFS option adds built in handlers declared in the stub files of fsys matching patterns (see fs.Glob),
+// all the .yaml, .yml and .json files at the root of fsys are loaded if no patterns are given.
+// Files referenced as a bodyFile by the loaded stubs are not loaded as stub files
+var WithStubsFS = func(fsys fs.FS, patterns ...string) ServerOption {
+	return func(o *serverOptions, isUpdate bool) error {
+		stubs, err := loadStubsFS(fsys, patterns...)
+		if err != nil {
+			return err
+		}
+		for _, opts := range stubs {
+			handler, err := newRequestHandler(opts...)
+			if err != nil {
+				return err
+			}
+			o.defaultRequestHandlers = append(o.defaultRequestHandlers, handler)
+		}
+		return nil
+	}
+}
+
+// WithAdminAPI option serves the admin API on the server under prefix, e.g. /__admin.
+// Admin requests are not counted, recorded or matched to handlers
+// This option cannot be changed after the server is created
+var WithAdminAPI = func(prefix string) ServerOption {
+	return func(o *serverOptions, isUpdate bool) error {
+		if isUpdate {
+			return fmt.Errorf("admin API option can't be updated")
+		}
+		if !strings.HasPrefix(prefix, "/") || len(prefix) < 2 {
+			return fmt.Errorf("admin API prefix must start with / and must not be empty")
+		}
+		o.adminPrefix = strings.TrimSuffix(prefix, "/")
+		return nil
+	}
+}
+
+// WithAdminPort option serves the admin API on a separate port, 0 picks the next available port
+// This option cannot be changed after the server is created
+var WithAdminPort = func(port int) ServerOption {
+	return func(o *serverOptions, isUpdate bool) error {
+		if isUpdate {
+			return fmt.Errorf("admin port can't be updated")
+		}
+		o.adminPort = &port
+		return nil
+	}
+}
+
 // Options for test server
 type serverOptions struct {
 	port                   int
+	host                   string
 	tls                    bool //
+	tlsCertificate         *tls.Certificate
+	ca                     *certificateAuthority
+	clientAuth             bool
+	clientCAs              *x509.CertPool
+	http2                  bool
+	h2c                    bool
 	recordFolder           string
 	recordAfterReqNum      int
 	record                 bool
 	recordOnlyUnhandled    bool
 	headers                map[string]string
-	defaultRequestHandlers []serverRequestHandler
-	middleware             []serverRequestHandler
+	defaultRequestHandlers []*serverRequestHandler
+	middleware             []*serverRequestHandler
+	firstMatchRouting      bool
+	//builtInRequestsUncounted excludes the requests handled only by built-in handlers from the request count
+	builtInRequestsUncounted bool
+	unmatchedStatusCode      int
+	unmatchedT               *testing.T
+	faults                   []Fault
+	proxy                    *upstreamProxy
+	adminPrefix              string
+	adminPort                *int
+	rand                     *rand.Rand
 }
 
 func makeServerOptions(opts ...ServerOption) (*serverOptions, error) {
 	o := &serverOptions{
 		port:                   0,
+		host:                   localHost,
 		tls:                    false,
-		defaultRequestHandlers: []serverRequestHandler{},
-		middleware:             []serverRequestHandler{},
+		defaultRequestHandlers: []*serverRequestHandler{},
+		middleware:             []*serverRequestHandler{},
 		headers:                map[string]string{},
 		record:                 false,
 		recordFolder:           "",
 		recordAfterReqNum:      0,
+		rand:                   rand.New(rand.NewSource(time.Now().UnixNano())),
 	}
 	return applyOptions(o, false, opts...)
 }
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	sseContentType    = "text/event-stream"
+	ndjsonContentType = "application/x-ndjson"
+	streamContentType = "application/octet-stream"
+)
+
+// SSEEvent is a Server-Sent Event written by WithSSEResponse and WithSSEStream
+type SSEEvent struct {
+	//ID sets the last event id of the client, omitted if empty
+	ID string
+	//Event is the event type, omitted if empty
+	Event string
+	//Data is split into a data field per line
+	Data string
+	//Retry sets the reconnection time of the client, omitted if 0
+	Retry time.Duration
+}
+
+func (e SSEEvent) encode() []byte {
+	var b strings.Builder
+	if e.ID != "" {
+		fmt.Fprintf(&b, "id: %s\n", e.ID)
+	}
+	if e.Event != "" {
+		fmt.Fprintf(&b, "event: %s\n", e.Event)
+	}
+	if e.Retry != 0 {
+		fmt.Fprintf(&b, "retry: %d\n", e.Retry.Milliseconds())
+	}
+	for _, line := range strings.Split(e.Data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+	return []byte(b.String())
+}
+
+// responseStream writes the body of a streamed response chunk by chunk, each chunk is flushed to the client
+type responseStream struct {
+	contentType string
+	//run writes the chunks until the stream ends, ctx is done or write fails
+	run func(ctx context.Context, write func(chunk []byte) bool)
+}
+
+// timedStream writes the chunks with interval between them
+func timedStream(contentType string, interval time.Duration, chunks [][]byte) *responseStream {
+	return &responseStream{
+		contentType: contentType,
+		run: func(ctx context.Context, write func(chunk []byte) bool) {
+			for i, chunk := range chunks {
+				if i != 0 && interval > 0 {
+					timer := time.NewTimer(interval)
+					select {
+					case <-timer.C:
+					case <-ctx.Done():
+						timer.Stop()
+						return
+					}
+				}
+				if !write(chunk) {
+					return
+				}
+			}
+		},
+	}
+}
+
+// channelStream writes the chunks received from the channel until it is closed
+func channelStream(contentType string, next func(ctx context.Context) ([]byte, bool)) *responseStream {
+	return &responseStream{
+		contentType: contentType,
+		run: func(ctx context.Context, write func(chunk []byte) bool) {
+			for {
+				chunk, ok := next(ctx)
+				if !ok || !write(chunk) {
+					return
+				}
+			}
+		},
+	}
+}
+
+// serve writes the stream until it ends, the client disconnects or the server is closed
+func (s *responseStream) serve(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-serverClosed(r):
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	flusher, _ := w.(http.Flusher)
+	//send the headers before the first chunk so the client can start reading
+	if flusher != nil {
+		flusher.Flush()
+	}
+	s.run(ctx, func(chunk []byte) bool {
+		if ctx.Err() != nil {
+			return false
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return false
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return true
+	})
+}
+
+func sseEvents(events []SSEEvent) [][]byte {
+	chunks := make([][]byte, 0, len(events))
+	for _, event := range events {
+		chunks = append(chunks, event.encode())
+	}
+	return chunks
+}
+
+func ndjsonLine(v interface{}) ([]byte, error) {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode NDJSON line: %v", err)
+	}
+	return append(line, '\n'), nil
+}
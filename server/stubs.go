@@ -0,0 +1,491 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Stub files declare handlers in YAML or JSON, see the "Stub files" section of the README for the format.
+// Each stub is mapped to the RequestHandlerOption of the equivalent Go handler.
+
+// defaultStubPatterns are the files loaded from a stubs file system if no patterns are given
+var defaultStubPatterns = []string{"*.yaml", "*.yml", "*.json"}
+
+type stubFile struct {
+	Stubs []stubDefinition `yaml:"stubs"`
+}
+
+type stubDefinition struct {
+	Name      string           `yaml:"name"`
+	Request   stubRequest      `yaml:"request"`
+	Response  *stubResponse    `yaml:"response"`
+	Responses []stubResponse   `yaml:"responses"`
+	Delay     string           `yaml:"delay"`
+	Priority  int              `yaml:"priority"`
+	Scenario  *stubScenario    `yaml:"scenario"`
+	Expect    *stubExpectation `yaml:"expect"`
+}
+
+type stubRequest struct {
+	Method        string                 `yaml:"method"`
+	Path          string                 `yaml:"path"`
+	PathPrefix    string                 `yaml:"pathPrefix"`
+	PathSuffix    string                 `yaml:"pathSuffix"`
+	PathTemplate  string                 `yaml:"pathTemplate"`
+	PathGlob      string                 `yaml:"pathGlob"`
+	PathRegex     string                 `yaml:"pathRegex"`
+	RequestNumber int                    `yaml:"requestNumber"`
+	Ordinal       int                    `yaml:"ordinal"`
+	OrdinalRange  *stubOrdinalRange      `yaml:"ordinalRange"`
+	Query         map[string]string      `yaml:"query"`
+	QueryPresent  []string               `yaml:"queryPresent"`
+	Headers       map[string]string      `yaml:"headers"`
+	HeadersRegex  map[string]string      `yaml:"headersRegex"`
+	JSONBody      interface{}            `yaml:"jsonBody"`
+	JSONPath      map[string]interface{} `yaml:"jsonPath"`
+}
+
+type stubOrdinalRange struct {
+	From int `yaml:"from"`
+	To   int `yaml:"to"`
+}
+
+type stubResponse struct {
+	Status   int               `yaml:"status"`
+	Headers  map[string]string `yaml:"headers"`
+	Body     string            `yaml:"body"`
+	JSON     interface{}       `yaml:"json"`
+	BodyFile string            `yaml:"bodyFile"`
+	Template string            `yaml:"template"`
+}
+
+type stubScenario struct {
+	Name          string `yaml:"name"`
+	RequiredState string `yaml:"requiredState"`
+	NewState      string `yaml:"newState"`
+}
+
+type stubExpectation struct {
+	Times   *int `yaml:"times"`
+	AtLeast *int `yaml:"atLeast"`
+	AtMost  *int `yaml:"atMost"`
+}
+
+// stubFieldError is an error of a stub field, located in the stub file by its field path
+type stubFieldError struct {
+	fieldPath []string
+	err       error
+}
+
+func (e *stubFieldError) Error() string {
+	return fmt.Sprintf("%s: %v", strings.Join(e.fieldPath, "."), e.err)
+}
+
+// stubOption wraps a handler option so its errors are reported with the stub field that produced it
+func stubOption(opt RequestHandlerOption, fieldPath ...string) RequestHandlerOption {
+	return func(o *requestHandlerOptions) error {
+		if err := opt(o); err != nil {
+			return &stubFieldError{fieldPath: fieldPath, err: err}
+		}
+		return nil
+	}
+}
+
+// loadStubsFS loads the stubs of the files matching patterns in fsys
+func loadStubsFS(fsys fs.FS, patterns ...string) ([][]RequestHandlerOption, error) {
+	if len(patterns) == 0 {
+		patterns = defaultStubPatterns
+	}
+	fileNames := []string{}
+	for _, pattern := range patterns {
+		matches, err := fs.Glob(fsys, pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stubs pattern %s: %v", pattern, err)
+		}
+		fileNames = append(fileNames, matches...)
+	}
+	sort.Strings(fileNames)
+	files := map[string][]byte{}
+	bodyFiles := map[string]bool{}
+	for _, fileName := range fileNames {
+		data, err := fs.ReadFile(fsys, fileName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stub file %s: %v", fileName, err)
+		}
+		files[fileName] = data
+		for _, bodyFile := range stubBodyFiles(data, path.Dir(fileName)) {
+			bodyFiles[bodyFile] = true
+		}
+	}
+	stubs := [][]RequestHandlerOption{}
+	for _, fileName := range fileNames {
+		if bodyFiles[fileName] {
+			//a body file next to the stub files matches the patterns, it is not a stub file
+			continue
+		}
+		fileStubs, err := parseStubs(files[fileName], fileName, fsys, path.Dir(fileName))
+		if err != nil {
+			return nil, err
+		}
+		stubs = append(stubs, fileStubs...)
+	}
+	return stubs, nil
+}
+
+// stubBodyFiles returns the body files referenced by the stubs of a stub file, relative to the root of its file system.
+// Files that fail to parse have no body files, the error is reported when the stubs are parsed
+func stubBodyFiles(data []byte, dir string) []string {
+	root := yaml.Node{}
+	if err := yaml.Unmarshal(data, &root); err != nil || len(root.Content) == 0 {
+		return nil
+	}
+	stubNodes := []*yaml.Node{root.Content[0]}
+	if stubsNode := mappingValue(root.Content[0], "stubs"); stubsNode != nil {
+		stubNodes = stubsNode.Content
+	} else if root.Content[0].Kind == yaml.SequenceNode {
+		stubNodes = root.Content[0].Content
+	}
+	bodyFiles := []string{}
+	for _, stubNode := range stubNodes {
+		responseNodes := []*yaml.Node{}
+		if responseNode := mappingValue(stubNode, "response"); responseNode != nil {
+			responseNodes = append(responseNodes, responseNode)
+		}
+		if responsesNode := mappingValue(stubNode, "responses"); responsesNode != nil {
+			responseNodes = append(responseNodes, responsesNode.Content...)
+		}
+		for _, responseNode := range responseNodes {
+			if bodyFile := mappingValue(responseNode, "bodyFile"); bodyFile != nil && bodyFile.Kind == yaml.ScalarNode {
+				bodyFiles = append(bodyFiles, path.Join(dir, bodyFile.Value))
+			}
+		}
+	}
+	return bodyFiles
+}
+
+// parseStubs parses a stub file and returns the handler options of each stub, errors are reported with the file name and line.
+// The file holds either a "stubs" list, a list of stubs or a single stub at its root, body files are read from fsys relative to dir
+func parseStubs(data []byte, fileName string, fsys fs.FS, dir string) ([][]RequestHandlerOption, error) {
+	root := yaml.Node{}
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("%s: %v", fileName, err)
+	}
+	if len(root.Content) == 0 {
+		return [][]RequestHandlerOption{}, nil
+	}
+	document := root.Content[0]
+	stubsNode := document
+	if document.Kind == yaml.MappingNode {
+		if stubsNode = mappingValue(document, "stubs"); stubsNode == nil {
+			//a single stub
+			stubsNode = &yaml.Node{Kind: yaml.SequenceNode, Line: document.Line, Content: []*yaml.Node{document}}
+		} else if err := checkStubFields(document, reflect.TypeOf(stubFile{})); err != nil {
+			return nil, fmt.Errorf("%s:%v", fileName, err)
+		}
+	}
+	if stubsNode.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("%s:%d: expected a list of stubs", fileName, stubsNode.Line)
+	}
+	stubs := [][]RequestHandlerOption{}
+	for i, stubNode := range stubsNode.Content {
+		if err := checkStubFields(stubNode, reflect.TypeOf(stubDefinition{})); err != nil {
+			return nil, fmt.Errorf("%s:%v", fileName, err)
+		}
+		stub := stubDefinition{}
+		if err := stubNode.Decode(&stub); err != nil {
+			line, msg := decodeErrorLine(err, stubNode.Line)
+			return nil, fmt.Errorf("%s:%d: stub #%d: %s", fileName, line, i, msg)
+		}
+		opts, err := stub.options(fsys, dir)
+		if err == nil {
+			err = validateHandlerOptions(opts...)
+		}
+		if err != nil {
+			line := stubNode.Line
+			var fieldErr *stubFieldError
+			if errors.As(err, &fieldErr) {
+				line = fieldLine(stubNode, fieldErr.fieldPath)
+			}
+			return nil, fmt.Errorf("%s:%d: stub %s: %v", fileName, line, stub.describe(i), err)
+		}
+		stubs = append(stubs, opts)
+	}
+	return stubs, nil
+}
+
+func (s *stubDefinition) describe(index int) string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return fmt.Sprintf("#%d", index)
+}
+
+// options maps the stub to the equivalent handler options
+func (s *stubDefinition) options(fsys fs.FS, dir string) ([]RequestHandlerOption, error) {
+	opts := []RequestHandlerOption{}
+	add := func(opt RequestHandlerOption, fieldPath ...string) {
+		opts = append(opts, stubOption(opt, fieldPath...))
+	}
+	if s.Name != "" {
+		add(WithName(s.Name), "name")
+	}
+
+	req := s.Request
+	if req.Method != "" {
+		add(WithMethod(req.Method), "request", "method")
+	}
+	if req.Path != "" {
+		add(WithPath(req.Path), "request", "path")
+	}
+	if req.PathPrefix != "" {
+		add(WithPathPrefix(req.PathPrefix), "request", "pathPrefix")
+	}
+	if req.PathSuffix != "" {
+		add(WithPathSuffix(req.PathSuffix), "request", "pathSuffix")
+	}
+	if req.PathTemplate != "" {
+		add(WithPathTemplate(req.PathTemplate), "request", "pathTemplate")
+	}
+	if req.PathGlob != "" {
+		add(WithPathGlob(req.PathGlob), "request", "pathGlob")
+	}
+	if req.PathRegex != "" {
+		add(WithPathRegex(req.PathRegex), "request", "pathRegex")
+	}
+	if req.RequestNumber != 0 {
+		add(WithRequestNumber(req.RequestNumber), "request", "requestNumber")
+	}
+	if req.Ordinal != 0 && req.OrdinalRange != nil {
+		return nil, &stubFieldError{fieldPath: []string{"request", "ordinalRange"}, err: fmt.Errorf("only one of ordinal and ordinalRange can be set")}
+	}
+	if req.Ordinal != 0 {
+		add(WithOrdinal(req.Ordinal), "request", "ordinal")
+	}
+	if req.OrdinalRange != nil {
+		add(WithOrdinalRange(req.OrdinalRange.From, req.OrdinalRange.To), "request", "ordinalRange")
+	}
+	for _, key := range sortedKeys(req.Query) {
+		add(WithQueryParam(key, req.Query[key]), "request", "query", key)
+	}
+	for _, key := range req.QueryPresent {
+		add(WithQueryParamPresent(key), "request", "queryPresent")
+	}
+	for _, key := range sortedKeys(req.Headers) {
+		add(WithRequestHeader(key, req.Headers[key]), "request", "headers", key)
+	}
+	for _, key := range sortedKeys(req.HeadersRegex) {
+		add(WithRequestHeaderRegex(key, req.HeadersRegex[key]), "request", "headersRegex", key)
+	}
+	if req.JSONBody != nil {
+		add(WithJSONBody(req.JSONBody), "request", "jsonBody")
+	}
+	jsonPaths := make([]string, 0, len(req.JSONPath))
+	for jsonPath := range req.JSONPath {
+		jsonPaths = append(jsonPaths, jsonPath)
+	}
+	sort.Strings(jsonPaths)
+	for _, jsonPath := range jsonPaths {
+		add(WithJSONPathValue(jsonPath, req.JSONPath[jsonPath]), "request", "jsonPath", jsonPath)
+	}
+
+	if s.Response != nil && len(s.Responses) != 0 {
+		return nil, &stubFieldError{fieldPath: []string{"responses"}, err: fmt.Errorf("response and responses can't be set together")}
+	}
+	if s.Response != nil {
+		response, err := s.Response.toResponse(fsys, dir)
+		if err != nil {
+			return nil, &stubFieldError{fieldPath: []string{"response"}, err: err}
+		}
+		add(WithStatusCode(response.StatusCode), "response", "status")
+		add(WithResponseHeaders(response.Headers), "response", "headers")
+		add(WithResponse(response.Body), "response")
+		if response.Template != "" {
+			add(WithResponseTemplate(response.Template), "response", "template")
+		}
+	}
+	if len(s.Responses) != 0 {
+		responses := []Response{}
+		for i := range s.Responses {
+			response, err := s.Responses[i].toResponse(fsys, dir)
+			if err != nil {
+				return nil, &stubFieldError{fieldPath: []string{"responses", fmt.Sprint(i)}, err: err}
+			}
+			responses = append(responses, response)
+		}
+		add(WithStatusResponses(responses), "responses")
+	}
+
+	if s.Delay != "" {
+		delay, err := time.ParseDuration(s.Delay)
+		if err != nil {
+			return nil, &stubFieldError{fieldPath: []string{"delay"}, err: err}
+		}
+		add(WithDelay(delay), "delay")
+	}
+	if s.Priority != 0 {
+		add(WithPriority(s.Priority), "priority")
+	}
+	if s.Scenario != nil {
+		add(WithScenario(s.Scenario.Name, s.Scenario.RequiredState), "scenario", "name")
+		if s.Scenario.NewState != "" {
+			add(WithNewScenarioState(s.Scenario.NewState), "scenario", "newState")
+		}
+	}
+	if s.Expect != nil {
+		set := 0
+		for _, isSet := range []bool{s.Expect.Times != nil, s.Expect.AtLeast != nil, s.Expect.AtMost != nil} {
+			if isSet {
+				set++
+			}
+		}
+		if set > 1 {
+			return nil, &stubFieldError{fieldPath: []string{"expect"}, err: fmt.Errorf("only one of times, atLeast and atMost can be set")}
+		}
+		switch {
+		case s.Expect.Times != nil:
+			add(WithTimes(*s.Expect.Times), "expect", "times")
+		case s.Expect.AtLeast != nil:
+			add(WithAtLeast(*s.Expect.AtLeast), "expect", "atLeast")
+		case s.Expect.AtMost != nil:
+			add(WithAtMost(*s.Expect.AtMost), "expect", "atMost")
+		}
+	}
+	return opts, nil
+}
+
+func (r *stubResponse) toResponse(fsys fs.FS, dir string) (Response, error) {
+	response := Response{StatusCode: r.Status, Headers: r.Headers, JSON: r.JSON, Template: r.Template}
+	set := 0
+	for _, isSet := range []bool{r.Body != "", r.JSON != nil, r.BodyFile != "", r.Template != ""} {
+		if isSet {
+			set++
+		}
+	}
+	if set > 1 {
+		return response, fmt.Errorf("only one of body, json, bodyFile and template can be set")
+	}
+	response.Body = []byte(r.Body)
+	if r.BodyFile != "" {
+		if fsys == nil {
+			return response, fmt.Errorf("bodyFile is not supported for this stub source")
+		}
+		body, err := fs.ReadFile(fsys, path.Join(dir, r.BodyFile))
+		if err != nil {
+			return response, fmt.Errorf("failed to read body file: %v", err)
+		}
+		response.Body = body
+	}
+	if err := response.prepare(); err != nil {
+		return response, err
+	}
+	return response, nil
+}
+
+// checkStubFields reports fields of the node that are unknown to the type t, using the yaml field tags of t
+func checkStubFields(node *yaml.Node, t reflect.Type) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			if node.Tag == "!!null" {
+				return nil
+			}
+			return fmt.Errorf("%d: expected a mapping", node.Line)
+		}
+		fields := map[string]reflect.Type{}
+		for i := 0; i < t.NumField(); i++ {
+			name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+			fields[name] = t.Field(i).Type
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			fieldType, ok := fields[key.Value]
+			if !ok {
+				return fmt.Errorf("%d: unknown field %s", key.Line, key.Value)
+			}
+			if err := checkStubFields(node.Content[i+1], fieldType); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return nil
+		}
+		for _, item := range node.Content {
+			if err := checkStubFields(item, t.Elem()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// yamlErrorLine matches the errors of yaml.TypeError, e.g. "line 4: cannot unmarshal !!str `a` into map[string]string"
+var yamlErrorLine = regexp.MustCompile(`^line (\d+): (.*)$`)
+
+// decodeErrorLine returns the line and the message of the first error of a decoding error, defaultLine if it has no line
+func decodeErrorLine(err error, defaultLine int) (int, string) {
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) && len(typeErr.Errors) != 0 {
+		if match := yamlErrorLine.FindStringSubmatch(typeErr.Errors[0]); match != nil {
+			line, _ := strconv.Atoi(match[1])
+			return line, match[2]
+		}
+		return defaultLine, typeErr.Errors[0]
+	}
+	return defaultLine, err.Error()
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// fieldLine returns the line of the deepest node found along fieldPath
+func fieldLine(node *yaml.Node, fieldPath []string) int {
+	line := node.Line
+	for _, field := range fieldPath {
+		var next *yaml.Node
+		if node.Kind == yaml.SequenceNode {
+			var index int
+			if _, err := fmt.Sscan(field, &index); err == nil && index >= 0 && index < len(node.Content) {
+				next = node.Content[index]
+			}
+		} else {
+			next = mappingValue(node, field)
+		}
+		if next == nil {
+			break
+		}
+		node, line = next, next.Line
+	}
+	return line
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParseStubsErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		fileName string
+		data     string
+		expected string
+	}{
+		{
+			name:     "unknown field",
+			fileName: "stubs.yaml",
+			data:     "stubs:\n  - request:\n      path: /a\n      pth: /b\n",
+			expected: "stubs.yaml:4: unknown field pth",
+		},
+		{
+			name:     "invalid path regex",
+			fileName: "stubs.yaml",
+			data:     "- name: bad-regex\n  request:\n    pathRegex: '['\n",
+			expected: "stubs.yaml:3: stub bad-regex: request.pathRegex: ",
+		},
+		{
+			name:     "response and responses",
+			fileName: "stubs.yaml",
+			data:     "request:\n  path: /a\nresponse:\n  status: 200\nresponses:\n  - status: 500\n",
+			expected: "stubs.yaml:6: stub #0: responses: response and responses can't be set together",
+		},
+		{
+			name:     "several bodies",
+			fileName: "stubs.yaml",
+			data:     "request:\n  path: /a\nresponse:\n  body: x\n  json: {a: 1}\n",
+			expected: "stubs.yaml:4: stub #0: response: only one of body, json, bodyFile and template can be set",
+		},
+		{
+			name:     "invalid status",
+			fileName: "stubs.yaml",
+			data:     "request:\n  path: /a\nresponses:\n  - status: 200\n  - status: 42\n",
+			expected: "stubs.yaml:5: stub #0: responses.1: invalid status code 42",
+		},
+		{
+			name:     "invalid delay",
+			fileName: "stubs.yaml",
+			data:     "- request:\n    path: /a\n- request:\n    path: /b\n  delay: soon\n",
+			expected: "stubs.yaml:5: stub #1: delay: ",
+		},
+		{
+			name:     "several expectations",
+			fileName: "stubs.yaml",
+			data:     "request:\n  path: /a\nexpect:\n  times: 1\n  atLeast: 2\n",
+			expected: "stubs.yaml:4: stub #0: expect: only one of times, atLeast and atMost can be set",
+		},
+		{
+			name:     "ordinal and ordinal range",
+			fileName: "stubs.yaml",
+			data:     "request:\n  path: /a\n  ordinal: 1\n  ordinalRange: {from: 2, to: 3}\n",
+			expected: "stubs.yaml:4: stub #0: request.ordinalRange: only one of ordinal and ordinalRange can be set",
+		},
+		{
+			name:     "invalid ordinal range",
+			fileName: "stubs.yaml",
+			data:     "request:\n  path: /a\n  ordinalRange:\n    from: 3\n    to: 2\n",
+			expected: "stubs.yaml:4: stub #0: request.ordinalRange: invalid ordinal range 3-2",
+		},
+		{
+			name:     "json file",
+			fileName: "stubs.json",
+			data:     "{\n  \"stubs\": [\n    {\n      \"request\": {\"method\": \"GET\", \"query\": \"a\"}\n    }\n  ]\n}\n",
+			expected: "stubs.json:4: stub #0: cannot unmarshal !!str `a` into map[string]string",
+		},
+		{
+			name:     "not a list",
+			fileName: "stubs.yaml",
+			data:     "stubs: 3\n",
+			expected: "stubs.yaml:1: expected a list of stubs",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseStubs([]byte(test.data), test.fileName, nil, ".")
+			if err == nil {
+				t.Fatalf("expected error %q", test.expected)
+			}
+			if !strings.HasPrefix(err.Error(), test.expected) {
+				t.Errorf("expected error starting with %q got %q", test.expected, err.Error())
+			}
+		})
+	}
+}
+
+func TestStubsServeResponses(t *testing.T) {
+	fsys := fstest.MapFS{
+		"stubs/documents.yaml": {Data: []byte(`
+stubs:
+  - name: get-document
+    request:
+      method: GET
+      pathTemplate: /{index}/_doc/{id}
+    response:
+      status: 200
+      headers: {X-Elastic-Product: Elasticsearch}
+      bodyFile: responses/doc.json
+  - request:
+      path: /_bulk
+    responses:
+      - status: 500
+      - status: 200
+        json: {errors: false}
+`)},
+		"stubs/responses/doc.json": {Data: []byte(`{"found": true}`)},
+	}
+	ts := NewTestServerWithCleanup(t, WithStubsFS(fsys, "stubs/*.yaml"))
+
+	resp, err := http.Get(ts.GetURL() + "/index/_doc/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Elastic-Product") != "Elasticsearch" {
+		t.Errorf("unexpected response %d %v", resp.StatusCode, resp.Header)
+	}
+	if body := string(doRequest(t, http.MethodGet, ts.GetURL()+"/index/_doc/1").body); body != `{"found": true}` {
+		t.Errorf("unexpected body %s", body)
+	}
+
+	for _, expected := range []int{http.StatusInternalServerError, http.StatusOK} {
+		if status := doRequest(t, http.MethodPost, ts.GetURL()+"/_bulk").status; status != expected {
+			t.Errorf("expected status %d got %d", expected, status)
+		}
+	}
+}
+
+func TestStubsDirWithBodyFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"stubs.yaml": "- request:\n    path: /doc\n  response:\n    bodyFile: doc.json\n- request:\n    path: /empty\n  responses:\n    - bodyFile: empty.json\n",
+		"doc.json":   `{"found": true}`,
+		//an empty object is a valid stub matching any request if loaded as a stub file
+		"empty.json": `{}`,
+		"more.json":  `{"request": {"path": "/more"}, "response": {"status": 202}}`,
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ts := NewTestServerWithCleanup(t, WithStubsDir(dir), WithUnmatchedResponse(http.StatusNotFound))
+	if handlers := ts.GetHandlers(); len(handlers) != 3 {
+		t.Errorf("expected 3 stubs got %d", len(handlers))
+	}
+	if body := string(doRequest(t, http.MethodGet, ts.GetURL()+"/doc").body); body != files["doc.json"] {
+		t.Errorf("unexpected body %s", body)
+	}
+	if status := doRequest(t, http.MethodGet, ts.GetURL()+"/more").status; status != http.StatusAccepted {
+		t.Errorf("expected status %d got %d", http.StatusAccepted, status)
+	}
+	if status := doRequest(t, http.MethodGet, ts.GetURL()+"/other").status; status != http.StatusNotFound {
+		t.Errorf("expected status %d got %d", http.StatusNotFound, status)
+	}
+}
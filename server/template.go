@@ -0,0 +1,121 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// TemplateData is the request data available to response templates
+type TemplateData struct {
+	Method string
+	Path   string
+	//PathParams are the parameters captured by the path template or regex of the handler
+	PathParams map[string]string
+	//Query holds the first value of each query parameter, QueryValues all of them
+	Query       map[string]string
+	QueryValues map[string][]string
+	//Headers holds the first value of each header by its canonical name, HeaderValues all of them
+	Headers      map[string]string
+	HeaderValues map[string][]string
+	//Body is the raw request body and JSON the parsed body, nil if the body is not JSON
+	Body string
+	JSON interface{}
+}
+
+// responseTemplate is a parsed response body template with its own counters
+type responseTemplate struct {
+	template *template.Template
+	mux      sync.Mutex
+	counters map[string]int
+}
+
+// parseResponseTemplate parses a text/template with the helper functions:
+// uuid returns a random UUID, now returns the current time in RFC 3339 or the given layout, counter increments and returns a named counter
+// starting from 1, json encodes a value as JSON and jsonPath returns the value at a JSONPath expression
+func parseResponseTemplate(source string) (*responseTemplate, error) {
+	rt := &responseTemplate{counters: map[string]int{}}
+	tmpl, err := template.New("response").Option("missingkey=zero").Funcs(template.FuncMap{
+		"uuid":     newUUID,
+		"now":      now,
+		"counter":  rt.counter,
+		"json":     toJSON,
+		"jsonPath": templateJSONPath,
+	}).Parse(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response template: %v", err)
+	}
+	rt.template = tmpl
+	return rt, nil
+}
+
+// render executes the template with the data of the request
+func (rt *responseTemplate) render(r *http.Request, reqBody string) ([]byte, error) {
+	data := TemplateData{
+		Method:       r.Method,
+		Path:         r.URL.Path,
+		PathParams:   PathParams(r),
+		Query:        map[string]string{},
+		QueryValues:  r.URL.Query(),
+		Headers:      map[string]string{},
+		HeaderValues: r.Header.Clone(),
+		Body:         reqBody,
+	}
+	for k, v := range data.QueryValues {
+		data.Query[k] = v[0]
+	}
+	for k, v := range data.HeaderValues {
+		if len(v) != 0 {
+			data.Headers[k] = v[0]
+		}
+	}
+	json.Unmarshal([]byte(reqBody), &data.JSON)
+	var body bytes.Buffer
+	if err := rt.template.Execute(&body, data); err != nil {
+		return nil, fmt.Errorf("failed to render response template: %v", err)
+	}
+	return body.Bytes(), nil
+}
+
+func (rt *responseTemplate) counter(name string) int {
+	rt.mux.Lock()
+	defer rt.mux.Unlock()
+	rt.counters[name]++
+	return rt.counters[name]
+}
+
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	//version 4, variant 10
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+func now(layout ...string) string {
+	if len(layout) != 0 {
+		return time.Now().UTC().Format(layout[0])
+	}
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+func toJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func templateJSONPath(value interface{}, path string) interface{} {
+	result, _ := jsonPathLookup(value, path)
+	return result
+}
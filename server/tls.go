@@ -0,0 +1,207 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	certificateValidity = 24 * time.Hour
+	// defaultClientCommonName is the common name of the client certificate used by the server Client with mutual TLS
+	defaultClientCommonName = "ca-test-client"
+)
+
+// defaultSANs are the subject alternative names of a generated server certificate if none are given
+var defaultSANs = []string{localHost, "localhost"}
+
+// certificateAuthority is a generated CA issuing the server and client certificates
+type certificateAuthority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newCertificateAuthority() (*certificateAuthority, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          newSerialNumber(),
+		Subject:               pkix.Name{CommonName: "ca-test CA"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(certificateValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &certificateAuthority{cert: cert, key: key}, nil
+}
+
+// issue creates a certificate signed by the CA, sans are IP addresses or DNS names
+func (ca *certificateAuthority) issue(commonName string, sans []string, extKeyUsage x509.ExtKeyUsage) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: newSerialNumber(),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(certificateValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{extKeyUsage},
+	}
+	for _, san := range sans {
+		if ip := net.ParseIP(san); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, san)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der, ca.cert.Raw}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+func newSerialNumber() *big.Int {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	return serial
+}
+
+// certPool returns the pool trusting the server certificate, the CA if it was generated or the certificate chain otherwise
+func (o *serverOptions) certPool(serverCert *x509.Certificate) *x509.CertPool {
+	pool := x509.NewCertPool()
+	if o.ca != nil {
+		pool.AddCert(o.ca.cert)
+		return pool
+	}
+	pool.AddCert(serverCert)
+	if o.tlsCertificate != nil {
+		for _, der := range o.tlsCertificate.Certificate[1:] {
+			if cert, err := x509.ParseCertificate(der); err == nil {
+				pool.AddCert(cert)
+			}
+		}
+	}
+	return pool
+}
+
+// prepareTLS generates a CA for mutual TLS without a clientCAs pool and a server certificate, if no certificate was given
+func (o *serverOptions) prepareTLS() error {
+	if !o.clientAuth || o.clientCAs != nil || o.ca != nil {
+		return nil
+	}
+	if o.tlsCertificate != nil {
+		return fmt.Errorf("client auth with a custom TLS certificate requires a client CAs pool")
+	}
+	return WithGeneratedCA()(o, false)
+}
+
+// tlsConfig returns the server TLS configuration, nil certificates make httptest use its built in certificate
+func (o *serverOptions) tlsConfig() *tls.Config {
+	config := &tls.Config{}
+	if o.tlsCertificate != nil {
+		config.Certificates = []tls.Certificate{*o.tlsCertificate}
+	}
+	if o.clientAuth {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		config.ClientCAs = o.clientCAs
+		if config.ClientCAs == nil && o.ca != nil {
+			config.ClientCAs = x509.NewCertPool()
+			config.ClientCAs.AddCert(o.ca.cert)
+		}
+	}
+	return config
+}
+
+// newClient creates a client trusting the server certificate, with a client certificate for mutual TLS if the CA was generated,
+// the client speaks HTTP/2 if the server does
+func (ts *mockTestingServer) newClient() (*http.Client, error) {
+	if ts.options.h2c {
+		return &http.Client{Transport: newH2CTransport()}, nil
+	}
+	if !ts.options.tls {
+		return &http.Client{Transport: &http.Transport{}}, nil
+	}
+	clientConfig := &tls.Config{RootCAs: ts.certPool}
+	if ts.options.clientAuth && ts.options.ca != nil {
+		clientCert, err := ts.IssueClientCertificate(defaultClientCommonName)
+		if err != nil {
+			return nil, err
+		}
+		clientConfig.Certificates = []tls.Certificate{clientCert}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig, ForceAttemptHTTP2: ts.options.http2}}, nil
+}
+
+func (ts *mockTestingServer) Client() *http.Client {
+	return ts.client
+}
+
+func (ts *mockTestingServer) CertPool() *x509.CertPool {
+	return ts.certPool
+}
+
+func (ts *mockTestingServer) IssueClientCertificate(commonName string) (tls.Certificate, error) {
+	if ts.options.ca == nil {
+		return tls.Certificate{}, fmt.Errorf("client certificates can be issued only with a generated CA, see WithGeneratedCA")
+	}
+	return ts.options.ca.issue(commonName, nil, x509.ExtKeyUsageClientAuth)
+}
+
+// clientIdentity returns the common name, or the subject if there is no common name, of the verified client certificate
+func clientIdentity(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+	subject := r.TLS.PeerCertificates[0].Subject
+	if subject.CommonName != "" {
+		return subject.CommonName
+	}
+	return subject.String()
+}
+
+// parseCertificate parses a PEM encoded certificate chain and private key
+func parseCertificate(certPEM, keyPEM []byte) (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS certificate: %v", err)
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, fmt.Errorf("invalid TLS certificate: %v", err)
+	}
+	return &cert, nil
+}
+
+// EncodeCertificatePEM returns the PEM encoding of the certificate chain, e.g. to write a CA bundle for other processes
+func EncodeCertificatePEM(certs ...*x509.Certificate) []byte {
+	encoded := []byte{}
+	for _, cert := range certs {
+		encoded = append(encoded, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return encoded
+}
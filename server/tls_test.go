@@ -0,0 +1,62 @@
+package server
+
+import (
+	"crypto/tls"
+	"net/http"
+	"testing"
+)
+
+func TestMutualTLS(t *testing.T) {
+	ts := NewTestServerWithCleanup(t, WithClientAuth(nil))
+	resp, err := ts.Client().Get(ts.GetURL() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	clientCert, err := ts.IssueClientCertificate("indexer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: ts.CertPool(), Certificates: []tls.Certificate{clientCert}}}}
+	resp, err = client.Get(ts.GetURL() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	requests := ts.GetRequests()
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 requests got %d", len(requests))
+	}
+	for i, expected := range []string{defaultClientCommonName, "indexer"} {
+		if requests[i].ClientIdentity != expected {
+			t.Errorf("request %d: expected client identity %s got %s", i+1, expected, requests[i].ClientIdentity)
+		}
+	}
+}
+
+func TestMutualTLSRejectsClients(t *testing.T) {
+	ts := NewTestServerWithCleanup(t, WithClientAuth(nil))
+	other := NewTestServerWithCleanup(t, WithGeneratedCA())
+	otherCert, err := other.IssueClientCertificate("intruder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, certificates := range map[string][]tls.Certificate{
+		"no certificate":          nil,
+		"certificate of other CA": {otherCert},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: ts.CertPool(), Certificates: certificates}}}
+			resp, err := client.Get(ts.GetURL() + "/")
+			if err == nil {
+				resp.Body.Close()
+				t.Fatalf("expected the handshake to fail got status %d", resp.StatusCode)
+			}
+		})
+	}
+	if count := ts.GetRequestCount(); count != 0 {
+		t.Errorf("expected the rejected requests not to be journaled got %d", count)
+	}
+}
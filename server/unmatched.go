@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// maximum number of nearest miss handlers reported for an unmatched request
+const maxNearestMisses = 3
+
+type nearestMiss struct {
+	Handler        string   `json:"handler"`
+	FailedMatchers []string `json:"failedMatchers"`
+}
+
+type unmatchedRequestResponse struct {
+	Error         string        `json:"error"`
+	Method        string        `json:"method"`
+	URL           string        `json:"url"`
+	NearestMisses []nearestMiss `json:"nearestMisses"`
+}
+
+// handleUnmatched applies the unmatched requests policy (see WithUnmatchedResponse and WithStrictUnmatched) to a request no handler matched
+func handleUnmatched(w http.ResponseWriter, r *http.Request, d *dispatchedRequest) {
+	if d.options.unmatchedStatusCode == 0 && d.options.unmatchedT == nil {
+		return
+	}
+	if t := d.options.unmatchedT; t != nil {
+		descriptions := []string{}
+		for _, miss := range d.nearestMisses {
+			descriptions = append(descriptions, fmt.Sprintf("%s (%s)", miss.Handler, strings.Join(miss.FailedMatchers, ", ")))
+		}
+		t.Errorf("request #%d %s %s was not matched by any handler, nearest misses:\n\t%s", d.reqNum, r.Method, r.URL, strings.Join(descriptions, "\n\t"))
+	}
+	if d.options.unmatchedStatusCode == 0 {
+		return
+	}
+	body, _ := json.Marshal(&unmatchedRequestResponse{
+		Error:         "no handler matched the request",
+		Method:        r.Method,
+		URL:           r.URL.String(),
+		NearestMisses: d.nearestMisses,
+	})
+	w.Header().Set(contentTypeHeader, jsonContentType)
+	w.WriteHeader(d.options.unmatchedStatusCode)
+	w.Write(body)
+}
+
+// nearestMisses returns the handlers that failed the least matchers for the request, the caller holds the server locks
+func (ts *mockTestingServer) nearestMisses(matches requestMatches, reqNum int) []nearestMiss {
+	misses := []nearestMiss{}
+	handlers := append(append([]*serverRequestHandler{}, ts.options.defaultRequestHandlers...), ts.requestHandlers...)
+	for _, handler := range handlers {
+		if _, evaluated := matches[handler]; !evaluated {
+			//added after the request was received
+			continue
+		}
+		misses = append(misses, nearestMiss{
+			Handler:        handler.options.describe(),
+			FailedMatchers: handler.mismatches(matches, reqNum, ts.scenarios),
+		})
+	}
+	sort.SliceStable(misses, func(i, j int) bool {
+		return len(misses[i].FailedMatchers) < len(misses[j].FailedMatchers)
+	})
+	if len(misses) > maxNearestMisses {
+		misses = misses[:maxNearestMisses]
+	}
+	return misses
+}
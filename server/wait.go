@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// maximum number of received requests described in the error of WaitForRequests
+const maxDescribedRequests = 10
+
+func (ts *mockTestingServer) WaitForRequest(ctx context.Context, matchers ...RequestHandlerOption) (RecordedRequest, error) {
+	requests, err := ts.WaitForRequests(ctx, 1, matchers...)
+	if err != nil {
+		return RecordedRequest{}, err
+	}
+	return requests[0], nil
+}
+
+func (ts *mockTestingServer) WaitForRequests(ctx context.Context, n int, matchers ...RequestHandlerOption) ([]RecordedRequest, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("number of requests to wait for must be positive")
+	}
+	options, err := makeRequestHandlerOptions(matchers...)
+	if err != nil {
+		return nil, err
+	}
+	matched := []RecordedRequest{}
+	received := []RecordedRequest{}
+	ordinal := 0
+	for {
+		ts.mux.Lock()
+		newRequests := copyRecordedRequests(ts.journal[len(received):])
+		appended := ts.journalAppended
+		ts.mux.Unlock()
+
+		for _, request := range newRequests {
+			received = append(received, request)
+			if !options.matchRequest(request.toHTTPRequest(), request.Body, request.RequestNumber) {
+				continue
+			}
+			if ordinal++; options.ordinal == nil || options.ordinal.contains(ordinal) {
+				matched = append(matched, request)
+				if len(matched) == n {
+					return matched, nil
+				}
+			}
+		}
+		select {
+		case <-appended:
+		case <-ctx.Done():
+			return matched, waitError(ctx.Err().Error(), n, options, matched, received)
+		case <-ts.closed:
+			return matched, waitError("server closed", n, options, matched, received)
+		}
+	}
+}
+
+// waitError describes the last requests that were received and why they did not match
+func waitError(reason string, n int, options *requestHandlerOptions, matched, received []RecordedRequest) error {
+	msg := fmt.Sprintf("%s waiting for %d requests matching %s, %d matched out of %d received", reason, n, options.describe(), len(matched), len(received))
+	if len(received) > maxDescribedRequests {
+		msg += fmt.Sprintf(", last %d requests:", maxDescribedRequests)
+		received = received[len(received)-maxDescribedRequests:]
+	} else if len(received) != 0 {
+		msg += ":"
+	}
+	for _, request := range received {
+		msg += fmt.Sprintf("\n\t#%d %s %s", request.RequestNumber, request.Method, request.URL)
+		if mismatches := options.mismatches(request.toHTTPRequest(), request.Body, request.RequestNumber); len(mismatches) != 0 {
+			msg += " (" + strings.Join(mismatches, ", ") + ")"
+		}
+	}
+	return fmt.Errorf("%s", msg)
+}
+
+// notifyJournalAppended wakes up the WaitForRequests calls, the caller holds the server lock
+func (ts *mockTestingServer) notifyJournalAppended() {
+	close(ts.journalAppended)
+	ts.journalAppended = make(chan struct{})
+}
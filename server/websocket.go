@@ -0,0 +1,382 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocketDirection is the direction of a WebSocket frame
+type WebSocketDirection string
+
+const (
+	// WebSocketInbound is a frame sent by the client
+	WebSocketInbound WebSocketDirection = "in"
+	// WebSocketOutbound is a frame sent by the server
+	WebSocketOutbound WebSocketDirection = "out"
+)
+
+// webSocketQueueSize is the number of inbound messages buffered for the script of a connection
+const webSocketQueueSize = 64
+
+// WebSocketFrame is a WebSocket message or close frame captured by a WebSocketMock
+type WebSocketFrame struct {
+	//Connection is the number of the connection the frame was sent on, starting from 1
+	Connection int                `json:"connection"`
+	Direction  WebSocketDirection `json:"direction"`
+	//Type is text, binary or close
+	Type string `json:"type"`
+	Data string `json:"data,omitempty"`
+	//CloseCode is the status code of a close frame
+	CloseCode int       `json:"close_code,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// WebSocketStep is a step of the script each connection to a WebSocketMock runs in order,
+// custom steps exchange messages with the client through the session of the connection
+type WebSocketStep func(s WebSocketSession) error
+
+// WebSocketSession is a connection to a WebSocketMock as seen by the script steps
+type WebSocketSession interface {
+	//get the number of the connection, starting from 1
+	ID() int
+	//wait for the next message from the client, false is returned if the connection was closed
+	Next() ([]byte, bool)
+	//send a message to the client, messageType is websocket.TextMessage or websocket.BinaryMessage
+	Send(messageType int, data []byte) error
+	//close the connection with the given close code, e.g. websocket.CloseNormalClosure (1000)
+	Close(code int, reason string)
+}
+
+// ExpectWebSocketMessage step waits for the next message from the client, the connection is closed with a policy violation if it does not match
+var ExpectWebSocketMessage = func(match func(message []byte) bool) WebSocketStep {
+	return func(s WebSocketSession) error {
+		message, ok := s.Next()
+		if !ok {
+			return fmt.Errorf("connection closed while waiting for a message")
+		}
+		if !match(message) {
+			s.Close(websocket.ClosePolicyViolation, "unexpected message")
+			return fmt.Errorf("unexpected message %q", message)
+		}
+		return nil
+	}
+}
+
+// ExpectWebSocketText step waits for the next message from the client and expects it to be text
+var ExpectWebSocketText = func(text string) WebSocketStep {
+	return ExpectWebSocketMessage(func(message []byte) bool {
+		return string(message) == text
+	})
+}
+
+// SendWebSocketText step sends a text message to the client
+var SendWebSocketText = func(text string) WebSocketStep {
+	return func(s WebSocketSession) error {
+		return s.Send(websocket.TextMessage, []byte(text))
+	}
+}
+
+// SendWebSocketBinary step sends a binary message to the client
+var SendWebSocketBinary = func(data []byte) WebSocketStep {
+	return func(s WebSocketSession) error {
+		return s.Send(websocket.BinaryMessage, data)
+	}
+}
+
+// SendWebSocketJSON step sends v encoded as JSON in a text message to the client
+var SendWebSocketJSON = func(v interface{}) WebSocketStep {
+	return func(s WebSocketSession) error {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return s.Send(websocket.TextMessage, data)
+	}
+}
+
+// CloseWebSocket step closes the connection with the given close code, e.g. websocket.CloseNormalClosure (1000)
+var CloseWebSocket = func(code int, reason string) WebSocketStep {
+	return func(s WebSocketSession) error {
+		s.Close(code, reason)
+		return nil
+	}
+}
+
+// WebSocketMock serves WebSocket connections for the handlers it is set on with WithWebSocket,
+// each connection runs the script steps and the test can push messages to and wait for messages from the clients
+type WebSocketMock struct {
+	steps    []WebSocketStep
+	upgrader websocket.Upgrader
+
+	mux         sync.Mutex
+	sessions    []*webSocketSession
+	frames      []WebSocketFrame
+	errors      []error
+	framesAdded chan struct{}
+}
+
+func NewWebSocketMock(steps ...WebSocketStep) *WebSocketMock {
+	return &WebSocketMock{
+		steps: steps,
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+		framesAdded: make(chan struct{}),
+	}
+}
+
+// Push sends a text message to all the open connections, error is returned if there are no open connections
+func (ws *WebSocketMock) Push(message string) error {
+	return ws.push(websocket.TextMessage, []byte(message))
+}
+
+// PushJSON sends v encoded as JSON in a text message to all the open connections
+func (ws *WebSocketMock) PushJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ws.push(websocket.TextMessage, data)
+}
+
+func (ws *WebSocketMock) push(messageType int, data []byte) error {
+	sessions := ws.openSessions()
+	if len(sessions) == 0 {
+		return fmt.Errorf("no open websocket connections")
+	}
+	for _, s := range sessions {
+		if err := s.Send(messageType, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes all the open connections with the given close code
+func (ws *WebSocketMock) Close(code int, reason string) {
+	for _, s := range ws.openSessions() {
+		s.Close(code, reason)
+	}
+}
+
+// Connections returns the number of connections opened so far
+func (ws *WebSocketMock) Connections() int {
+	ws.mux.Lock()
+	defer ws.mux.Unlock()
+	return len(ws.sessions)
+}
+
+// Frames returns the frames sent and received on all the connections in the order they were sent or received
+func (ws *WebSocketMock) Frames() []WebSocketFrame {
+	ws.mux.Lock()
+	defer ws.mux.Unlock()
+	frames := make([]WebSocketFrame, len(ws.frames))
+	copy(frames, ws.frames)
+	return frames
+}
+
+// Errors returns the failures of the connections scripts, they are also reported by TestServer.Verify
+func (ws *WebSocketMock) Errors() []error {
+	ws.mux.Lock()
+	defer ws.mux.Unlock()
+	return append([]error{}, ws.errors...)
+}
+
+// WaitForMessage waits until a message matching the predicate is received from a client, messages received before the call are matched too
+func (ws *WebSocketMock) WaitForMessage(ctx context.Context, match func(message []byte) bool) (WebSocketFrame, error) {
+	checked := 0
+	for {
+		ws.mux.Lock()
+		frames, framesAdded := ws.frames[checked:], ws.framesAdded
+		checked = len(ws.frames)
+		ws.mux.Unlock()
+		for _, frame := range frames {
+			if frame.Direction == WebSocketInbound && frame.Type != "close" && match([]byte(frame.Data)) {
+				return frame, nil
+			}
+		}
+		select {
+		case <-framesAdded:
+		case <-ctx.Done():
+			return WebSocketFrame{}, fmt.Errorf("no matching websocket message received: %v", ctx.Err())
+		}
+	}
+}
+
+// serve upgrades the request and runs the connection in the background, so the request is not kept open by the server
+func (ws *WebSocketMock) serve(w http.ResponseWriter, r *http.Request, reqBody string) {
+	//the server headers are sent with the upgrade response
+	conn, err := ws.upgrader.Upgrade(w, r, w.Header().Clone())
+	if err != nil {
+		//the upgrader already responded with an error
+		return
+	}
+	ws.mux.Lock()
+	s := &webSocketSession{
+		id:       len(ws.sessions) + 1,
+		mock:     ws,
+		conn:     conn,
+		inbound:  make(chan []byte, webSocketQueueSize),
+		closed:   make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	ws.sessions = append(ws.sessions, s)
+	ws.mux.Unlock()
+
+	go s.read()
+	go s.run()
+	go func() {
+		select {
+		case <-serverClosed(r):
+			s.Close(websocket.CloseGoingAway, "server closed")
+		case <-s.closed:
+		}
+	}()
+}
+
+func (ws *WebSocketMock) openSessions() []*webSocketSession {
+	ws.mux.Lock()
+	defer ws.mux.Unlock()
+	open := []*webSocketSession{}
+	for _, s := range ws.sessions {
+		select {
+		case <-s.closed:
+		default:
+			open = append(open, s)
+		}
+	}
+	return open
+}
+
+func (ws *WebSocketMock) addFrame(frame WebSocketFrame) {
+	ws.mux.Lock()
+	defer ws.mux.Unlock()
+	frame.Timestamp = time.Now()
+	ws.frames = append(ws.frames, frame)
+	//wake up the waiting WaitForMessage calls
+	close(ws.framesAdded)
+	ws.framesAdded = make(chan struct{})
+}
+
+func (ws *WebSocketMock) addError(err error) {
+	ws.mux.Lock()
+	defer ws.mux.Unlock()
+	ws.errors = append(ws.errors, err)
+}
+
+// webSocketSession is a connection to a WebSocketMock
+type webSocketSession struct {
+	id       int
+	mock     *WebSocketMock
+	conn     *websocket.Conn
+	writeMux sync.Mutex
+	//inbound queues the received messages for the script
+	inbound   chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+	//finished is closed when the script completes, the received messages are not queued anymore
+	finished chan struct{}
+}
+
+// run runs the script steps in order, a failed step is recorded and stops the script
+func (s *webSocketSession) run() {
+	defer close(s.finished)
+	for i, step := range s.mock.steps {
+		if err := step(s); err != nil {
+			s.mock.addError(fmt.Errorf("websocket connection %d step %d: %v", s.id, i+1, err))
+			return
+		}
+	}
+}
+
+// read records the received frames and queues the messages for the script until the connection is closed
+func (s *webSocketSession) read() {
+	defer s.closeConn()
+	defer close(s.inbound)
+	for {
+		messageType, data, err := s.conn.ReadMessage()
+		if err != nil {
+			if closeErr, ok := err.(*websocket.CloseError); ok {
+				s.mock.addFrame(WebSocketFrame{Connection: s.id, Direction: WebSocketInbound, Type: "close", CloseCode: closeErr.Code, Data: closeErr.Text})
+				s.Close(closeErr.Code, "")
+			}
+			return
+		}
+		s.mock.addFrame(WebSocketFrame{Connection: s.id, Direction: WebSocketInbound, Type: messageTypeName(messageType), Data: string(data)})
+		select {
+		case s.inbound <- data:
+		case <-s.finished:
+		}
+	}
+}
+
+func (s *webSocketSession) ID() int {
+	return s.id
+}
+
+func (s *webSocketSession) Next() ([]byte, bool) {
+	message, ok := <-s.inbound
+	return message, ok
+}
+
+func (s *webSocketSession) Send(messageType int, data []byte) error {
+	s.writeMux.Lock()
+	defer s.writeMux.Unlock()
+	select {
+	case <-s.closed:
+		return fmt.Errorf("websocket connection %d is closed", s.id)
+	default:
+	}
+	if err := s.conn.WriteMessage(messageType, data); err != nil {
+		return err
+	}
+	s.mock.addFrame(WebSocketFrame{Connection: s.id, Direction: WebSocketOutbound, Type: messageTypeName(messageType), Data: string(data)})
+	return nil
+}
+
+// Close sends a close frame with the code, the reader closes the connection when the client acknowledges it or the connection fails
+func (s *webSocketSession) Close(code int, reason string) {
+	s.writeMux.Lock()
+	defer s.writeMux.Unlock()
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		message := websocket.FormatCloseMessage(code, reason)
+		if err := s.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second)); err == nil {
+			s.mock.addFrame(WebSocketFrame{Connection: s.id, Direction: WebSocketOutbound, Type: "close", CloseCode: code, Data: reason})
+		}
+		//unblock the reader if the client does not acknowledge the close frame
+		s.conn.SetReadDeadline(time.Now().Add(time.Second))
+	})
+}
+
+func (s *webSocketSession) closeConn() {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+	})
+	s.conn.Close()
+}
+
+func messageTypeName(messageType int) string {
+	if messageType == websocket.BinaryMessage {
+		return "binary"
+	}
+	return "text"
+}
+
+type serverClosedKey struct{}
+
+// withServerClosed adds the channel closed when the server is closed to the request context, used by handlers that outlive the request
+func withServerClosed(r *http.Request, closed chan struct{}) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), serverClosedKey{}, closed))
+}
+
+func serverClosed(r *http.Request) <-chan struct{} {
+	closed, _ := r.Context().Value(serverClosedKey{}).(chan struct{})
+	return closed
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWebSocketCustomStep(t *testing.T) {
+	echo := func(s WebSocketSession) error {
+		message, ok := s.Next()
+		if !ok {
+			return fmt.Errorf("connection closed")
+		}
+		return s.Send(websocket.TextMessage, append([]byte(fmt.Sprintf("echo %d: ", s.ID())), message...))
+	}
+	ws := NewWebSocketMock(echo, CloseWebSocket(websocket.CloseNormalClosure, "done"))
+	ts := NewTestServerWithCleanup(t)
+	if _, err := ts.AddHandler(WithPath("/ws"), WithWebSocket(ws)); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.GetURL(), "http")+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	_, message, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(message) != "echo 1: hello" {
+		t.Errorf("unexpected message %q", message)
+	}
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("expected a normal closure got %v", err)
+	}
+	if errs := ws.Errors(); len(errs) != 0 {
+		t.Errorf("unexpected script errors %v", errs)
+	}
+}